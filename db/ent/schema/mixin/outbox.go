@@ -0,0 +1,59 @@
+package mixin
+
+import (
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+	"entgo.io/ent/schema/mixin"
+	"time"
+)
+
+// -------------------------------------------------
+// Mixin definition
+// OutboxMixin implements the ent.Mixin for the
+// outbox table of go-zero-utils/queue/outbox.
+
+// Usage: Add a schema, the table name must match OutboxConf.Table
+//
+//	type Outbox struct {
+//		ent.Schema
+//	}
+//
+//	func (Outbox) Mixin() []ent.Mixin {
+//		return []ent.Mixin{mixin.OutboxMixin{}}
+//	}
+//
+//	func (Outbox) Annotations() []schema.Annotation {
+//		return []schema.Annotation{entsql.Annotation{Table: "outbox"}}
+//	}
+//
+// and enable the `sql/execquery` feature of ent, so the generated Tx can be passed to outbox.Add
+
+type OutboxMixin struct {
+	// We embed the `mixin.Schema` to avoid
+	// implementing the rest of the methods.
+	mixin.Schema
+}
+
+func (OutboxMixin) Fields() []ent.Field {
+	return []ent.Field{
+
+		field.String("jid").Unique().Immutable().Comment("任务id"),
+		field.String("queue").Immutable().Comment("队列"),
+		field.String("ordering_key").Default("").Immutable().Comment("相同key的任务按插入顺序投递"),
+		field.Text("payload").Immutable().Comment("任务json"),
+		field.Int("attempts").Default(0).Comment("投递次数"),
+		field.Text("last_error").Optional().Comment("最后一次投递错误"),
+		field.Time("next_at").Default(time.Now).Comment("下次投递时间"),
+		field.Time("sent_at").Optional().Nillable().Comment("投递成功时间"),
+		field.Time("failed_at").Optional().Nillable().Comment("放弃投递时间"),
+		field.Time("created_at").Immutable().Default(time.Now).Comment("创建时间"),
+	}
+}
+
+func (OutboxMixin) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("sent_at"),
+		index.Fields("ordering_key"),
+	}
+}
@@ -0,0 +1,150 @@
+package faktory
+
+import (
+	"context"
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/queue"
+	"time"
+)
+
+// the types of the Faktory client used by Admin
+type (
+	BatchStatus = faktory.BatchStatus
+	JobTrack    = faktory.JobTrack
+	JobFilter   = faktory.JobFilter
+	Structure   = faktory.Structure
+)
+
+// the sets of the Mutate API
+const (
+	Scheduled = faktory.Scheduled
+	Retries   = faktory.Retries
+	Dead      = faktory.Dead
+)
+
+// the filters of the Mutate API, e.g. OfType("sync_goods").WithJids(jid)
+var (
+	Everything = faktory.Everything
+	WithJids   = faktory.WithJids
+	Matching   = faktory.Matching
+	OfType     = faktory.OfType
+)
+
+// Batch describes a Faktory Enterprise batch, see Admin.PushBatch
+type Batch struct {
+	Description string
+	ParentBid   string     // the batch is a child of ParentBid
+	Success     *queue.Job // pushed once all the jobs succeeded
+	Complete    *queue.Job // pushed once all the jobs ran, successfully or not
+}
+
+// Admin is the batch, job tracking and mutate API of the server. Batches and job tracking
+// need Faktory Enterprise, the server answers ERR otherwise.
+type Admin struct {
+	client *faktoryClient
+}
+
+func (c *faktoryClient) Admin() *Admin {
+	return &Admin{client: c}
+}
+
+// PushBatch pushes jobs in a new batch and commits it, the callbacks of batch are pushed by the server
+// once the jobs finished. A batch failing to commit never calls back, its jobs run anyway.
+func (a *Admin) PushBatch(ctx context.Context, batch Batch, jobs ...*queue.Job) (bid string, err error) {
+	err = a.client.senderPool.With(func(cl *faktory.Client) error {
+		b := faktory.NewBatch(cl)
+		b.Description = batch.Description
+		b.ParentBid = batch.ParentBid
+		if batch.Success != nil {
+			b.Success = toFaktoryJob(batch.Success)
+		}
+		if batch.Complete != nil {
+			b.Complete = toFaktoryJob(batch.Complete)
+		}
+
+		err := b.Jobs(func() error {
+			return a.pushBatchJobs(ctx, b, jobs)
+		})
+		bid = b.Bid
+		return err
+	})
+	return bid, err
+}
+
+// AddToBatch reopens the batch bid to push jobs in it, e.g. from one of its jobs, then commits it again
+func (a *Admin) AddToBatch(ctx context.Context, bid string, jobs ...*queue.Job) error {
+	return a.client.senderPool.With(func(cl *faktory.Client) error {
+		b, err := cl.BatchOpen(bid)
+		if err != nil {
+			return err
+		}
+		return b.Jobs(func() error {
+			return a.pushBatchJobs(ctx, b, jobs)
+		})
+	})
+}
+
+func (a *Admin) pushBatchJobs(ctx context.Context, b *faktory.Batch, jobs []*queue.Job) error {
+	for _, job := range jobs {
+		// the jobs of a batch are pushed in the trace of ctx
+		queue.InjectTrace(ctx, job)
+		job.SetCustom("bid", b.Bid)
+
+		pushedAt := time.Now()
+		err := b.Push(toFaktoryJob(job))
+		a.client.metrics.Push(job.Queue, err)
+		if err != nil {
+			return err
+		}
+		a.client.status.Enqueued(job, pushedAt)
+	}
+	return nil
+}
+
+// BatchStatus returns the progress of the batch bid
+func (a *Admin) BatchStatus(bid string) (status *BatchStatus, err error) {
+	err = a.client.senderPool.With(func(cl *faktory.Client) error {
+		status, err = cl.BatchStatus(bid)
+		return err
+	})
+	return status, err
+}
+
+// JobStatus returns the tracking state of the job jid, see queue.Helper.TrackProgress
+func (a *Admin) JobStatus(jid string) (track *JobTrack, err error) {
+	err = a.client.senderPool.With(func(cl *faktory.Client) error {
+		track, err = cl.TrackGet(jid)
+		return err
+	})
+	return track, err
+}
+
+// Requeue moves the jobs of set matching filter to their queue, to run right away:
+//
+//	admin.Requeue(faktory.Retries, faktory.OfType("sync_goods"))
+func (a *Admin) Requeue(set Structure, filter JobFilter) error {
+	return a.client.senderPool.With(func(cl *faktory.Client) error {
+		return cl.Requeue(set, filter)
+	})
+}
+
+// Kill moves the jobs of set matching filter to the dead set
+func (a *Admin) Kill(set Structure, filter JobFilter) error {
+	return a.client.senderPool.With(func(cl *faktory.Client) error {
+		return cl.Kill(set, filter)
+	})
+}
+
+// Discard deletes the jobs of set matching filter
+func (a *Admin) Discard(set Structure, filter JobFilter) error {
+	return a.client.senderPool.With(func(cl *faktory.Client) error {
+		return cl.Discard(set, filter)
+	})
+}
+
+// Clear deletes every job of set, it is much faster than Discard with Everything
+func (a *Admin) Clear(set Structure) error {
+	return a.client.senderPool.With(func(cl *faktory.Client) error {
+		return cl.Clear(set)
+	})
+}
@@ -0,0 +1,117 @@
+package faktory
+
+import (
+	"context"
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/faktory/faktorytest"
+	"github.com/toby1991/go-zero-utils/queue"
+	"testing"
+	"time"
+)
+
+func newTestAdmin(t *testing.T) (*Admin, *faktorytest.Server) {
+	server := faktorytest.NewServer()
+	t.Cleanup(server.Close)
+
+	c, err := NewFaktory(FaktoryConf{
+		Url:     server.Url(),
+		Timeout: time.Second,
+		Sender:  SenderConf{PoolCapacity: 1},
+		Worker:  WorkerConf{Concurrency: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Admin(), server
+}
+
+func TestAdmin_PushBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		failed      bool
+		wantPending int64
+		wantQueued  []string // job types queued once the jobs finished
+	}{
+		{name: "succeeded", wantQueued: []string{"sync_done", "sync_ok"}},
+		{name: "failed", failed: true, wantQueued: []string{"sync_done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin, server := newTestAdmin(t)
+
+			jobs := []*queue.Job{queue.NewJob("sync_goods", 1), queue.NewJob("sync_goods", 2)}
+			bid, err := admin.PushBatch(context.Background(), Batch{
+				Description: "sync",
+				Success:     queue.NewJob("sync_ok"),
+				Complete:    queue.NewJob("sync_done"),
+			}, jobs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := admin.BatchStatus(bid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Total != 2 || status.Pending != 2 {
+				t.Errorf("total = %d, pending = %d, want 2, 2", status.Total, status.Pending)
+			}
+
+			server.Finish(jobs[0].Jid, false)
+			server.Finish(jobs[1].Jid, tt.failed)
+
+			var queued []string
+			for _, job := range server.Queued() {
+				queued = append(queued, job.Type)
+			}
+			if len(queued) != len(tt.wantQueued) {
+				t.Fatalf("queued = %v, want %v", queued, tt.wantQueued)
+			}
+			for i := range queued {
+				if queued[i] != tt.wantQueued[i] {
+					t.Errorf("queued = %v, want %v", queued, tt.wantQueued)
+				}
+			}
+		})
+	}
+}
+
+func TestAdmin_JobStatus(t *testing.T) {
+	admin, _ := newTestAdmin(t)
+
+	track, err := admin.JobStatus("unknown-jid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.State != "unknown" {
+		t.Errorf("state = %q, want unknown", track.State)
+	}
+}
+
+func TestAdmin_Mutate(t *testing.T) {
+	admin, server := newTestAdmin(t)
+
+	goods := faktory.NewJob("sync_goods")
+	site := faktory.NewJob("sync_site")
+	server.AddToSet(Retries, goods, site)
+
+	if err := admin.Requeue(Retries, OfType("sync_goods")); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Kill(Retries, WithJids(site.Jid)); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Clear(Dead); err != nil {
+		t.Fatal(err)
+	}
+
+	if queued := server.Queued(); len(queued) != 1 || queued[0].Jid != goods.Jid {
+		t.Errorf("queued = %v, want %s", queued, goods.Jid)
+	}
+	if retries, dead := server.Set(Retries), server.Set(Dead); len(retries) != 0 || len(dead) != 0 {
+		t.Errorf("retries = %d, dead = %d, want 0, 0", len(retries), len(dead))
+	}
+	if ops := server.Mutations(); len(ops) != 3 || ops[0].Cmd != "requeue" || ops[2].Filter != nil {
+		t.Errorf("mutations = %+v", ops)
+	}
+}
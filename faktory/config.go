@@ -1,10 +1,22 @@
 package faktory
 
+import (
+	"github.com/toby1991/go-zero-utils/queue"
+	"time"
+)
+
 // docker exec -it faktory_container_name redis-cli -s /var/lib/faktory/db/redis.sock
 type FaktoryConf struct {
-	Url    string // tcp://:mypassword@faktory.example.com:7419
-	Sender SenderConf
-	Worker WorkerConf
+	Url                string        // tcp://:mypassword@faktory.example.com:7419, tcp+tls:// for TLS
+	Password           string        `json:",optional"`   // overrides the password of Url
+	TLS                bool          `json:",optional"`   // the same as a tcp+tls:// Url
+	InsecureSkipVerify bool          `json:",optional"`   // skip the verification of the server certificate
+	Timeout            time.Duration `json:",default=5s"` // dial timeout
+	Sender             SenderConf
+	Worker             WorkerConf
+
+	// Dlq only applies to jobs failed with queue.Dead errors, parking requires SetDeadLetterStore
+	Dlq queue.DlqConf
 }
 
 type SenderConf struct {
@@ -14,4 +26,5 @@ type SenderConf struct {
 type WorkerConf struct {
 	Concurrency                int            `json:",default=20"`              // worker pool = concurrency + 2 github.com/contribsys/faktory_worker_go@v1.6.0/manager.go:137
 	PullFromQueuesWithPriority map[string]int `json:",default={\"default\":1}"` // {"critical":3, "default":2, "bulk":1}
+	ShutdownTimeout            time.Duration  `json:",default=30s"`             // how long Stop waits for in-flight jobs
 }
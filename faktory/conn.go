@@ -0,0 +1,138 @@
+package faktory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	faktory "github.com/contribsys/faktory/client"
+	"net"
+	"net/url"
+	"sync"
+)
+
+var (
+	ErrUrlRequired = errors.New("faktory: Url is required")
+	ErrPoolClosed  = errors.New("faktory: pool is closed")
+)
+
+// newServer returns the server of conf, the process environment is not read
+func newServer(conf FaktoryConf) (*faktory.Server, error) {
+	if len(conf.Url) <= 0 {
+		return nil, ErrUrlRequired
+	}
+
+	// tcp://:mypassword@faktory.example.com:7419
+	uri, err := url.Parse(conf.Url)
+	if err != nil {
+		return nil, fmt.Errorf("faktory: invalid Url: %w", err)
+	}
+	if uri.Scheme != "tcp" && uri.Scheme != "tcp+tls" {
+		return nil, fmt.Errorf("faktory: unsupported Url scheme %q", uri.Scheme)
+	}
+
+	port := uri.Port()
+	if len(port) <= 0 {
+		port = "7419"
+	}
+
+	server := &faktory.Server{
+		Network:  uri.Scheme,
+		Address:  net.JoinHostPort(uri.Hostname(), port),
+		Password: conf.Password,
+		Timeout:  conf.Timeout,
+		TLS: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         uri.Hostname(),
+			InsecureSkipVerify: conf.InsecureSkipVerify,
+		},
+	}
+	if uri.User != nil {
+		server.Username = uri.User.Username()
+		if len(server.Password) <= 0 {
+			server.Password, _ = uri.User.Password()
+		}
+	}
+	if conf.TLS {
+		server.Network = "tcp+tls"
+	}
+
+	return server, nil
+}
+
+// clientPool keeps up to capacity idle clients of server, the clients are opened by faktory.Dial
+type clientPool struct {
+	server *faktory.Server
+
+	mu     sync.Mutex
+	idle   []*faktory.Client
+	size   int // capacity
+	closed bool
+}
+
+func newClientPool(server *faktory.Server, capacity int) (*clientPool, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("faktory: invalid pool capacity %d", capacity)
+	}
+	return &clientPool{server: server, size: capacity}, nil
+}
+
+// With runs fn with an idle client, or a new one. The client is kept unless fn failed otherwise than by
+// an ERR of the server, its connection may then be broken.
+func (p *clientPool) With(fn func(cl *faktory.Client) error) error {
+	cl, err := p.get()
+	if err != nil {
+		return err
+	}
+
+	err = fn(cl)
+
+	var protocolErr *faktory.ProtocolError
+	if err != nil && !errors.As(err, &protocolErr) {
+		cl.Close()
+		return err
+	}
+	p.put(cl)
+	return err
+}
+
+// Close closes the idle clients, the ones in use are closed once fn returned
+func (p *clientPool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+
+	for _, cl := range idle {
+		cl.Close()
+	}
+}
+
+func (p *clientPool) get() (*faktory.Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		cl := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return cl, nil
+	}
+	p.mu.Unlock()
+
+	return faktory.Dial(p.server, p.server.Password)
+}
+
+func (p *clientPool) put(cl *faktory.Client) {
+	p.mu.Lock()
+	if !p.closed && len(p.idle) < p.size {
+		p.idle = append(p.idle, cl)
+		cl = nil
+	}
+	p.mu.Unlock()
+
+	if cl != nil {
+		cl.Close()
+	}
+}
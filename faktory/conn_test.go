@@ -0,0 +1,113 @@
+package faktory
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/queue"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeServer accepts one connection, greets it with a salt and records the HELLO and the first command
+func fakeServer(t *testing.T) (addr string, hellos chan map[string]interface{}, commands chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	hellos = make(chan map[string]interface{}, 1)
+	commands = make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("+HI {\"v\":2,\"s\":\"salt\",\"i\":3}\r\n"))
+
+		line, _ := r.ReadString('\n')
+		var hello map[string]interface{}
+		json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(line), "HELLO ")), &hello)
+		hellos <- hello
+		conn.Write([]byte("+OK\r\n"))
+
+		line, _ = r.ReadString('\n')
+		commands <- strings.SplitN(line, " ", 2)[0]
+		conn.Write([]byte("+OK\r\n"))
+	}()
+
+	return listener.Addr().String(), hellos, commands
+}
+
+func TestNewFaktory(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		password string
+		want     string // password the server should see
+		wantErr  bool
+	}{
+		{name: "password in url", url: "tcp://bob:secret@%s", want: "secret"},
+		{name: "password overrides url", url: "tcp://bob:secret@%s", password: "other", want: "other"},
+		{name: "no url", wantErr: true},
+		{name: "unsupported scheme", url: "http://%s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, hellos, commands := fakeServer(t)
+
+			// the environment must not be used, even invalid
+			t.Setenv("FAKTORY_PROVIDER", "tcp://:wrong@127.0.0.1:1")
+			t.Setenv("FAKTORY_URL", "tcp://:wrong@127.0.0.1:1")
+
+			conf := FaktoryConf{Password: tt.password, Sender: SenderConf{PoolCapacity: 1}, Worker: WorkerConf{Concurrency: 1}}
+			if len(tt.url) > 0 {
+				conf.Url = strings.Replace(tt.url, "%s", addr, 1)
+			}
+			c, err := NewFaktory(conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFaktory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			err = c.senderPool.With(func(cl *faktory.Client) error {
+				if cl.Location != addr {
+					t.Errorf("Location = %s, want %s", cl.Location, addr)
+				}
+				return cl.Push(toFaktoryJob(queue.NewJob("kline_filling", 1)))
+			})
+			if err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+
+			hello := <-hellos
+			if hello["pwdhash"] != passwordHash(tt.want, "salt", 3) {
+				t.Errorf("pwdhash = %v, want hash of %q", hello["pwdhash"], tt.want)
+			}
+			if hello["username"] != "bob" {
+				t.Errorf("username = %v, want bob", hello["username"])
+			}
+			if command := <-commands; command != "PUSH" {
+				t.Errorf("command = %s, want PUSH", command)
+			}
+		})
+	}
+}
+
+// passwordHash is hex(sha256^iterations(password + salt)), as the server expects
+func passwordHash(password, salt string, iterations int) string {
+	hash := sha256.Sum256([]byte(password + salt))
+	for i := 1; i < iterations; i++ {
+		hash = sha256.Sum256(hash[:])
+	}
+	return fmt.Sprintf("%x", hash)
+}
@@ -0,0 +1,70 @@
+package faktory
+
+import (
+	"context"
+	"fmt"
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/queue"
+	"github.com/zeromicro/go-zero/core/logx"
+	"strings"
+)
+
+// the same as nsq, jobs failed with queue.Dead errors are moved to "<queue>-dlq" or parked, see queue.DlqConf,
+// add "<queue>-dlq" to Worker.PullFromQueuesWithPriority to reprocess them.
+//
+// other failures are retried by faktory and end up in its dead set.
+const QUEUE_DLQ_SUFFIX = "-dlq"
+
+type dlq struct {
+	pool    *clientPool
+	conf    queue.DlqConf
+	store   queue.DeadLetterStore // nil unless set by SetDeadLetterStore
+	metrics *queue.Metrics
+}
+
+func newDlq(pool *clientPool, conf queue.DlqConf, metrics *queue.Metrics) *dlq {
+	return &dlq{pool: pool, conf: conf, metrics: metrics}
+}
+
+func (d *dlq) RequeueDeadJob(job *queue.Job) error {
+	jobJsonBytes, err := job.JsonBytes()
+	if err != nil {
+		return err
+	}
+
+	logx.Alert(fmt.Sprintf("go-zero-utils: RequeueDeadJob: %s", string(jobJsonBytes)))
+
+	if d.conf.ModeFor(job.Queue) == queue.DlqPark {
+		err := d.Park(job)
+		if err == nil {
+			return nil
+		}
+		logx.Error("dlq park error, fallback to the dlq queue: ", err)
+	}
+
+	deadJob := toFaktoryJob(job)
+	deadJob.Queue = job.Queue + QUEUE_DLQ_SUFFIX
+	if err := d.pool.With(func(cl *faktory.Client) error {
+		return cl.Push(deadJob)
+	}); err != nil {
+		return err
+	}
+	d.metrics.Dead(job, queue.DeadActionDlq)
+	return nil
+}
+
+// Park stores the dead job until it is replayed or purged, jobs of "<queue>-dlq" are parked under <queue>
+func (d *dlq) Park(job *queue.Job) error {
+	if d.store == nil {
+		return queue.ErrNoDeadLetterStore
+	}
+
+	job.Queue = strings.TrimSuffix(job.Queue, QUEUE_DLQ_SUFFIX)
+
+	logx.Infof("go-zero-utils: park dead job %s", job.Jid)
+	if err := d.store.Add(context.Background(), job); err != nil {
+		return err
+	}
+	d.metrics.Dead(job, queue.DeadActionPark)
+	return nil
+}
@@ -0,0 +1,411 @@
+// Package faktorytest provides a fake Faktory server for tests, like net/http/httptest.
+package faktorytest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	faktory "github.com/contribsys/faktory/client"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fetchWait = 10 * time.Millisecond
+
+// Server speaks enough of the Faktory protocol for the faktory package: push, fetch, batches, job tracking
+// and mutate. The jobs are stored until a worker fetches and acks or fails them, Finish simulates their end.
+// Passwords are not checked.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	queued    []*faktory.Job
+	fetched   map[string]bool // jids of the queued jobs given to a worker
+	failures  map[string]string
+	sets      map[faktory.Structure][]*faktory.Job
+	batches   map[string]*batch
+	tracks    map[string]*faktory.JobTrack
+	mutations []faktory.Operation
+	nextBid   int
+}
+
+type batch struct {
+	def       faktory.Batch
+	status    faktory.BatchStatus
+	committed bool
+}
+
+// NewServer starts a server on a random local port, Close it once done
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("faktorytest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		listener: listener,
+		fetched:  make(map[string]bool),
+		failures: make(map[string]string),
+		sets:     make(map[faktory.Structure][]*faktory.Job),
+		batches:  make(map[string]*batch),
+		tracks:   make(map[string]*faktory.JobTrack),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Url returns the FaktoryConf.Url of the server
+func (s *Server) Url() string {
+	return "tcp://" + s.listener.Addr().String()
+}
+
+// Close stops the server, the open connections are closed by their clients
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Queued returns the jobs pushed and not finished yet
+func (s *Server) Queued() []*faktory.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*faktory.Job(nil), s.queued...)
+}
+
+// Set returns the jobs of the scheduled, retries or dead set
+func (s *Server) Set(name faktory.Structure) []*faktory.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*faktory.Job(nil), s.sets[name]...)
+}
+
+// AddToSet puts jobs in the scheduled, retries or dead set, e.g. before a mutate
+func (s *Server) AddToSet(name faktory.Structure, jobs ...*faktory.Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sets[name] = append(s.sets[name], jobs...)
+}
+
+// Mutations returns the MUTATE operations received
+func (s *Server) Mutations() []faktory.Operation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]faktory.Operation(nil), s.mutations...)
+}
+
+// Failures returns the error messages of the jobs failed by a worker, by jid
+func (s *Server) Failures() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures := make(map[string]string, len(s.failures))
+	for jid, message := range s.failures {
+		failures[jid] = message
+	}
+	return failures
+}
+
+// Finish ends the queued job jid, its batch pushes its callbacks once it is committed and all its jobs finished:
+// complete in any case, success if none failed
+func (s *Server) Finish(jid string, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finish(jid, failed)
+}
+
+func (s *Server) finish(jid string, failed bool) {
+	delete(s.fetched, jid)
+	for i, job := range s.queued {
+		if job.Jid != jid {
+			continue
+		}
+		s.queued = append(s.queued[:i], s.queued[i+1:]...)
+
+		bid, _ := job.GetCustom("bid")
+		b, ok := s.batches[fmt.Sprint(bid)]
+		if !ok {
+			return
+		}
+		b.status.Pending--
+		if failed {
+			b.status.Failed++
+		}
+		s.callbacks(b)
+		return
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply(w, "+HI {\"v\":2}")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, payload := parse(strings.TrimRight(line, "\r\n"))
+		if cmd == "END" {
+			return
+		}
+		response := s.command(cmd, payload)
+		if cmd == "FETCH" && response == bulk("") {
+			// the server blocks a few seconds if there is no job, the fake one a little
+			time.Sleep(fetchWait)
+		}
+		reply(w, response)
+	}
+}
+
+// parse splits the command from its payload, the batch and track commands are two words
+func parse(line string) (cmd string, payload string) {
+	parts := strings.SplitN(line, " ", 3)
+	switch {
+	case len(parts) >= 2 && (parts[0] == "BATCH" || parts[0] == "TRACK"):
+		cmd = parts[0] + " " + parts[1]
+		if len(parts) == 3 {
+			payload = parts[2]
+		}
+	case len(parts) >= 2:
+		cmd, payload = parts[0], strings.SplitN(line, " ", 2)[1]
+	default:
+		cmd = parts[0]
+	}
+	return cmd, payload
+}
+
+// command returns the response line of cmd
+func (s *Server) command(cmd string, payload string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd {
+	case "HELLO", "FLUSH", "BEAT":
+		return "+OK"
+	case "FETCH":
+		for _, job := range s.queued {
+			if !s.fetched[job.Jid] && contains(strings.Fields(payload), job.Queue) {
+				s.fetched[job.Jid] = true
+				return marshal(job)
+			}
+		}
+		return bulk("")
+	case "ACK":
+		var ack struct {
+			Jid string `json:"jid"`
+		}
+		if err := json.Unmarshal([]byte(payload), &ack); err != nil {
+			return "-ERR " + err.Error()
+		}
+		s.finish(ack.Jid, false)
+		return "+OK"
+	case "FAIL":
+		var failure struct {
+			Jid     string `json:"jid"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(payload), &failure); err != nil {
+			return "-ERR " + err.Error()
+		}
+		s.failures[failure.Jid] = failure.Message
+		s.finish(failure.Jid, true)
+		return "+OK"
+	case "PUSH":
+		var job faktory.Job
+		if err := json.Unmarshal([]byte(payload), &job); err != nil {
+			return "-ERR " + err.Error()
+		}
+		s.push(&job)
+		return "+OK"
+	case "PUSHB":
+		var jobs []*faktory.Job
+		if err := json.Unmarshal([]byte(payload), &jobs); err != nil {
+			return "-ERR " + err.Error()
+		}
+		for _, job := range jobs {
+			s.push(job)
+		}
+		return bulk("{}")
+	case "BATCH NEW":
+		var def faktory.Batch
+		if err := json.Unmarshal([]byte(payload), &def); err != nil {
+			return "-ERR " + err.Error()
+		}
+		s.nextBid++
+		def.Bid = fmt.Sprintf("b-%d", s.nextBid)
+		s.batches[def.Bid] = &batch{def: def, status: faktory.BatchStatus{
+			Bid:         def.Bid,
+			ParentBid:   def.ParentBid,
+			Description: def.Description,
+			CreatedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		}}
+		return bulk(def.Bid)
+	case "BATCH OPEN":
+		b, ok := s.batches[payload]
+		if !ok {
+			return "-ERR No such batch " + payload
+		}
+		b.committed = false
+		return bulk(payload)
+	case "BATCH COMMIT":
+		b, ok := s.batches[payload]
+		if !ok {
+			return "-ERR No such batch " + payload
+		}
+		b.committed = true
+		s.callbacks(b)
+		return "+OK"
+	case "BATCH STATUS":
+		b, ok := s.batches[payload]
+		if !ok {
+			return "-ERR No such batch " + payload
+		}
+		return marshal(b.status)
+	case "TRACK GET":
+		track, ok := s.tracks[payload]
+		if !ok {
+			return marshal(faktory.JobTrack{Jid: payload, State: "unknown"})
+		}
+		return marshal(track)
+	case "TRACK SET":
+		var track faktory.JobTrack
+		if err := json.Unmarshal([]byte(payload), &track); err != nil {
+			return "-ERR " + err.Error()
+		}
+		track.State = "working"
+		track.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+		s.tracks[track.Jid] = &track
+		return "+OK"
+	case "MUTATE":
+		var op faktory.Operation
+		if err := json.Unmarshal([]byte(payload), &op); err != nil {
+			return "-ERR " + err.Error()
+		}
+		s.mutations = append(s.mutations, op)
+		s.mutate(op)
+		return "+OK"
+	default:
+		return "-ERR Unknown command " + cmd
+	}
+}
+
+func (s *Server) push(job *faktory.Job) {
+	s.queued = append(s.queued, job)
+	s.tracks[job.Jid] = &faktory.JobTrack{Jid: job.Jid, State: "enqueued", UpdatedAt: time.Now().UTC().Format(time.RFC3339Nano)}
+
+	if bid, ok := job.GetCustom("bid"); ok {
+		if b, ok := s.batches[fmt.Sprint(bid)]; ok {
+			b.status.Total++
+			b.status.Pending++
+		}
+	}
+}
+
+// callbacks pushes the callbacks of b once it is committed and all its jobs finished
+func (s *Server) callbacks(b *batch) {
+	if !b.committed || b.status.Pending > 0 || len(b.status.CompleteState) > 0 {
+		return
+	}
+
+	if b.def.Complete != nil {
+		s.push(b.def.Complete)
+	}
+	b.status.CompleteState = "1"
+	if b.status.Failed <= 0 {
+		if b.def.Success != nil {
+			s.push(b.def.Success)
+		}
+		b.status.SuccessState = "1"
+	}
+}
+
+func (s *Server) mutate(op faktory.Operation) {
+	var kept, matched []*faktory.Job
+	for _, job := range s.sets[op.Target] {
+		if op.Filter == nil || match(*op.Filter, job) {
+			matched = append(matched, job)
+		} else {
+			kept = append(kept, job)
+		}
+	}
+
+	switch op.Cmd {
+	case "clear", "discard":
+		s.sets[op.Target] = kept
+	case "kill":
+		s.sets[op.Target] = kept
+		s.sets[faktory.Dead] = append(s.sets[faktory.Dead], matched...)
+	case "requeue":
+		s.sets[op.Target] = kept
+		s.queued = append(s.queued, matched...)
+	}
+}
+
+// match tells whether job passes filter, Regexp is a glob matched against the job payload, as redis does
+func match(filter faktory.JobFilter, job *faktory.Job) bool {
+	if len(filter.Jobtype) > 0 && filter.Jobtype != job.Type {
+		return false
+	}
+	if len(filter.Jids) > 0 {
+		found := false
+		for _, jid := range filter.Jids {
+			found = found || jid == job.Jid
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(filter.Regexp) > 0 {
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(filter.Regexp), `\*`, ".*")
+		payload, _ := json.Marshal(job)
+		if ok, _ := regexp.MatchString("^"+pattern+"$", string(payload)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func marshal(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "-ERR " + err.Error()
+	}
+	return bulk(string(data))
+}
+
+func bulk(data string) string {
+	return fmt.Sprintf("$%d\r\n%s", len(data), data)
+}
+
+func reply(w *bufio.Writer, line string) {
+	w.WriteString(line + "\r\n")
+	w.Flush()
+}
@@ -0,0 +1,102 @@
+package faktory
+
+import (
+	"context"
+	faktory "github.com/contribsys/faktory/client"
+	worker "github.com/contribsys/faktory_worker_go"
+	"github.com/toby1991/go-zero-utils/queue"
+	"time"
+)
+
+// helper exposes worker.Helper as queue.Helper
+type helper struct {
+	worker.Helper
+
+	job    *queue.Job
+	status *queue.StatusStore
+}
+
+// ensure type compatibility
+var _ queue.Helper = &helper{}
+
+// Caution: this method must only be called within the
+// context of an executing job, it panics otherwise, as worker.HelperFor.
+func HelperFor(ctx context.Context) queue.Helper {
+	return &helper{Helper: workerHelperFor(ctx)}
+}
+
+// TrackProgress records the progress in the status store as well, if one is set,
+// job tracking is a faktory enterprise feature, its error is ignored then.
+func (h *helper) TrackProgress(percent int, desc string, reserveUntil *time.Time) error {
+	err := h.Helper.TrackProgress(percent, desc, reserveUntil)
+	if h.status == nil || h.job == nil {
+		return err
+	}
+
+	h.status.Progress(h.job, percent, desc)
+	return nil
+}
+
+type jobHelperKey struct{}
+
+// jobHelper is the worker.Helper of the jobs run by workerManager
+type jobHelper struct {
+	job  *faktory.Job
+	pool *clientPool
+}
+
+// ensure type compatibility
+var _ worker.Helper = &jobHelper{}
+
+func workerHelperFor(ctx context.Context) worker.Helper {
+	help, ok := ctx.Value(jobHelperKey{}).(*jobHelper)
+	if !ok {
+		panic("go-zero-utils: invalid job context, cannot create faktory job helper")
+	}
+	return help
+}
+
+func (h *jobHelper) Jid() string {
+	return h.job.Jid
+}
+func (h *jobHelper) JobType() string {
+	return h.job.Type
+}
+func (h *jobHelper) Custom(key string) (value interface{}, ok bool) {
+	return h.job.GetCustom(key)
+}
+func (h *jobHelper) Bid() string {
+	bid, _ := h.job.GetCustom("bid")
+	s, _ := bid.(string)
+	return s
+}
+func (h *jobHelper) CallbackBid() string {
+	bid, _ := h.job.GetCustom("_bid")
+	s, _ := bid.(string)
+	return s
+}
+
+// Batch opens the batch of the job to push more jobs in it, requires Faktory Enterprise
+func (h *jobHelper) Batch(fn func(*faktory.Batch) error) error {
+	bid := h.Bid()
+	if len(bid) <= 0 {
+		return worker.NoAssociatedBatchError
+	}
+	return h.pool.With(func(cl *faktory.Client) error {
+		b, err := cl.BatchOpen(bid)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+func (h *jobHelper) With(fn func(*faktory.Client) error) error {
+	return h.pool.With(fn)
+}
+
+// TrackProgress requires Faktory Enterprise
+func (h *jobHelper) TrackProgress(percent int, desc string, reserveUntil *time.Time) error {
+	return h.pool.With(func(cl *faktory.Client) error {
+		return cl.TrackSet(h.job.Jid, percent, desc, reserveUntil)
+	})
+}
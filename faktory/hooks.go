@@ -0,0 +1,118 @@
+package faktory
+
+import (
+	"context"
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/queue"
+	"sync"
+	"sync/atomic"
+)
+
+// BeforeJobHook is called before every job, after its status is set to running
+type BeforeJobHook func(ctx context.Context, job *queue.Job)
+
+// AfterJobHook is called after every job with the error of its processor, before it is acked or failed
+type AfterJobHook func(ctx context.Context, job *queue.Job, err error)
+
+const (
+	stateIdle      int32 = iota // not started yet
+	stateRunning                // started, fetching jobs once the server is reached
+	stateQuiet                  // no more jobs fetched, the running ones finish
+	stateTerminate              // shutting down
+)
+
+// lifecycle follows the events of the worker manager, quiet and terminate may come from Stop
+// or from the Faktory server through the heartbeat
+type lifecycle struct {
+	state   int32
+	reached int32 // 1 while the last fetch or heartbeat succeeded
+	quieted chan struct{}
+	once    sync.Once
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{quieted: make(chan struct{})}
+}
+
+func (l *lifecycle) on(state int32) {
+	atomic.StoreInt32(&l.state, state)
+	if state >= stateQuiet {
+		l.once.Do(func() {
+			close(l.quieted)
+		})
+	}
+}
+
+// contacted records the result of a fetch or a heartbeat
+func (l *lifecycle) contacted(err error) {
+	if err != nil {
+		atomic.StoreInt32(&l.reached, 0)
+	} else {
+		atomic.StoreInt32(&l.reached, 1)
+	}
+}
+
+func (l *lifecycle) ready() bool {
+	return atomic.LoadInt32(&l.state) == stateRunning && atomic.LoadInt32(&l.reached) == 1
+}
+
+// register the lifecycle first, so it is up to date in the hooks registered later
+func (l *lifecycle) register(mgr *workerManager) {
+	mgr.contacted = l.contacted
+	mgr.On(workerStartup, func() error {
+		l.on(stateRunning)
+		return nil
+	})
+	mgr.On(workerQuiet, func() error {
+		l.on(stateQuiet)
+		return nil
+	})
+	mgr.On(workerShutdown, func() error {
+		l.on(stateTerminate)
+		return nil
+	})
+}
+
+// OnStartup registers fn to run once the worker starts, before it connects to the server and fetches jobs
+func (c *faktoryClient) OnStartup(fn func() error) {
+	c.workerMgr.On(workerStartup, fn)
+}
+
+// OnQuiet registers fn to run once the worker stops fetching jobs, by Stop or by the Faktory server
+func (c *faktoryClient) OnQuiet(fn func() error) {
+	c.workerMgr.On(workerQuiet, fn)
+}
+
+// OnShutdown registers fn to run when the worker shuts down. A terminate sent by the Faktory server
+// exits the process once the running jobs finished, fn is the last chance to clean up.
+func (c *faktoryClient) OnShutdown(fn func() error) {
+	c.workerMgr.On(workerShutdown, fn)
+}
+
+// BeforeJob registers hook to run before every job, hooks must be registered before Start
+func (c *faktoryClient) BeforeJob(hook BeforeJobHook) {
+	c.beforeJob = append(c.beforeJob, hook)
+}
+
+// AfterJob registers hook to run after every job, hooks must be registered before Start
+func (c *faktoryClient) AfterJob(hook AfterJobHook) {
+	c.afterJob = append(c.afterJob, hook)
+}
+
+// Ready reports whether the worker fetches jobs, e.g. for a readiness probe: it is started, not quiet,
+// and its last fetch or heartbeat succeeded
+func (c *faktoryClient) Ready() bool {
+	return c.lifecycle.ready()
+}
+
+// Quieted is closed once the worker stops fetching jobs for good, the service should stop accepting work
+func (c *faktoryClient) Quieted() <-chan struct{} {
+	return c.lifecycle.quieted
+}
+
+// chain runs the queue middlewares of the job type around the handler registered for it
+func (c *faktoryClient) chain(ctx context.Context, job *faktory.Job, next func(ctx context.Context) error) error {
+	return c.Then(job.Type, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+		return next(ctx)
+	})(ctx, queue.HelperFor(ctx), job.Args...)
+}
@@ -0,0 +1,82 @@
+package faktory
+
+import (
+	"context"
+	"errors"
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/queue"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	mgr := newWorkerManager(nil, WorkerConf{})
+	l := newLifecycle()
+	l.register(mgr)
+
+	quietHook := false
+	mgr.On(workerQuiet, func() error {
+		// the state is up to date in the hooks registered later
+		quietHook = !l.ready()
+		return nil
+	})
+
+	if l.ready() {
+		t.Error("ready before startup")
+	}
+	l.on(stateRunning)
+	if l.ready() {
+		t.Error("ready after startup, before the server was reached")
+	}
+	mgr.contact(nil)
+	if !l.ready() {
+		t.Error("not ready after a fetch")
+	}
+	mgr.contact(errors.New("connection refused"))
+	if l.ready() {
+		t.Error("ready after a failed fetch")
+	}
+	mgr.contact(nil)
+
+	mgr.Quiet()
+	if l.ready() || !quietHook {
+		t.Errorf("ready = %v, quiet hook = %v after quiet", l.ready(), quietHook)
+	}
+	select {
+	case <-l.quieted:
+	default:
+		t.Error("quieted not closed after quiet")
+	}
+}
+
+func Test_faktoryClient_chain(t *testing.T) {
+	c := &faktoryClient{}
+	var calls []string
+	c.UseFor("sync_goods", func(next queue.JobProcessor) queue.JobProcessor {
+		return func(helper queue.Helper, args ...interface{}) error {
+			calls = append(calls, "middleware")
+			return next(helper, args...)
+		}
+	})
+
+	for _, jobType := range []string{"sync_goods", "sync_site"} {
+		ctx, cancel := queue.JobContext(context.Background(), &helper{job: queue.NewJob(jobType)}, 0)
+		err := c.chain(ctx, faktory.NewJob(jobType), func(ctx context.Context) error {
+			calls = append(calls, jobType)
+			return nil
+		})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"middleware", "sync_goods", "sync_site"}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("calls = %v, want %v", calls, want)
+		}
+	}
+}
@@ -1,15 +1,29 @@
 package faktory
 
 import (
-	"context"
-	faktory "github.com/contribsys/faktory/client"
-	"github.com/zeromicro/go-zero/core/service"
+	"github.com/toby1991/go-zero-utils/queue"
 )
 
 type FaktoryClient interface {
-	service.Service
+	queue.Client
 
-	Context() context.Context
-	SetProcessor(jobNameProcessorMap map[string]JobProcessor)
-	Push(job *faktory.Job) error
+	SetProcessor(jobNameProcessorMap map[string]queue.JobProcessor)
+}
+
+// Lifecycle hooks into the worker manager, see hooks.go
+type Lifecycle interface {
+	OnStartup(fn func() error)
+	OnQuiet(fn func() error)
+	OnShutdown(fn func() error)
+	BeforeJob(hook BeforeJobHook)
+	AfterJob(hook AfterJobHook)
+
+	// Ready reports whether the worker fetches jobs from a reachable server, Quieted is closed once it stopped for good
+	Ready() bool
+	Quieted() <-chan struct{}
+}
+
+// AdminClient exposes the batch, job tracking and mutate API, see admin.go
+type AdminClient interface {
+	Admin() *Admin
 }
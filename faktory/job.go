@@ -0,0 +1,66 @@
+package faktory
+
+import (
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/queue"
+)
+
+// toFaktoryJob converts queue.Job to faktory.Job, both share the same wire format.
+func toFaktoryJob(job *queue.Job) *faktory.Job {
+	fJob := &faktory.Job{
+		Jid:        job.Jid,
+		Queue:      job.Queue,
+		Type:       job.Type,
+		Args:       job.Args,
+		CreatedAt:  job.CreatedAt,
+		EnqueuedAt: job.EnqueuedAt,
+		At:         job.At,
+		ReserveFor: job.ReserveFor,
+		Retry:      job.Retry,
+		Backtrace:  job.Backtrace,
+		Custom:     job.Custom,
+	}
+	if job.Failure != nil {
+		fJob.Failure = &faktory.Failure{
+			RetryCount:     job.Failure.RetryCount,
+			RetryRemaining: job.Failure.RetryRemaining,
+			FailedAt:       job.Failure.FailedAt,
+			NextAt:         job.Failure.NextAt,
+			ErrorMessage:   job.Failure.ErrorMessage,
+			ErrorType:      job.Failure.ErrorType,
+			Backtrace:      job.Failure.Backtrace,
+		}
+	}
+
+	return fJob
+}
+
+// fromFaktoryJob converts faktory.Job to queue.Job, both share the same wire format.
+func fromFaktoryJob(fJob *faktory.Job) *queue.Job {
+	job := &queue.Job{
+		Jid:        fJob.Jid,
+		Queue:      fJob.Queue,
+		Type:       fJob.Type,
+		Args:       fJob.Args,
+		CreatedAt:  fJob.CreatedAt,
+		EnqueuedAt: fJob.EnqueuedAt,
+		At:         fJob.At,
+		ReserveFor: fJob.ReserveFor,
+		Retry:      fJob.Retry,
+		Backtrace:  fJob.Backtrace,
+		Custom:     fJob.Custom,
+	}
+	if fJob.Failure != nil {
+		job.Failure = &queue.Failure{
+			RetryCount:     fJob.Failure.RetryCount,
+			RetryRemaining: fJob.Failure.RetryRemaining,
+			FailedAt:       fJob.Failure.FailedAt,
+			NextAt:         fJob.Failure.NextAt,
+			ErrorMessage:   fJob.Failure.ErrorMessage,
+			ErrorType:      fJob.Failure.ErrorType,
+			Backtrace:      fJob.Failure.Backtrace,
+		}
+	}
+
+	return job
+}
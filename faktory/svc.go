@@ -2,19 +2,41 @@ package faktory
 
 import (
 	"context"
-	"github.com/zeromicro/go-zero/core/logx"
-	"os"
+	"errors"
+	"github.com/toby1991/go-zero-utils/queue"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
 )
 import faktory "github.com/contribsys/faktory/client"
-import worker "github.com/contribsys/faktory_worker_go"
 
-type JobProcessor func(helper worker.Helper, args ...interface{}) error
+// ensure type compatibility
+var (
+	_ FaktoryClient       = &faktoryClient{}
+	_ queue.BulkPusher    = &faktoryClient{}
+	_ queue.DeadLetterer  = &faktoryClient{}
+	_ queue.GroupPusher   = &faktoryClient{}
+	_ queue.StatusTracker = &faktoryClient{}
+	_ Lifecycle           = &faktoryClient{}
+	_ AdminClient         = &faktoryClient{}
+)
 
 type faktoryClient struct {
+	queue.Middlewares
+
 	_conf               FaktoryConf
-	senderPool          *faktory.Pool
-	workerMgr           *worker.Manager
-	jobNameProcessorMap map[string]JobProcessor
+	senderPool          *clientPool
+	dlq                 *dlq
+	workerMgr           *workerManager
+	jobNameProcessorMap map[string]queue.ContextJobProcessor
+	inflight            queue.Inflight
+	status              *queue.StatusStore
+	metrics             *queue.Metrics
+	workflow            *queue.Workflow
+	lifecycle           *lifecycle
+	beforeJob           []BeforeJobHook
+	afterJob            []AfterJobHook
+	terminated          chan struct{} // closed once the worker manager terminated
 	ctx                 context.Context
 	cancel              context.CancelFunc
 }
@@ -23,72 +45,211 @@ func (c *faktoryClient) Start() {
 	c.processing(context.Background(), c.jobNameProcessorMap)
 }
 
+// Stop shuts down in order: stop fetching new jobs, wait for in-flight jobs up to
+// Worker.ShutdownTimeout, then cancel the jobs still running and terminate the worker manager,
+// it waits up to Worker.ShutdownTimeout again for the OnShutdown hooks.
 func (c *faktoryClient) Stop() {
-	c.cancel()
-}
+	c.workerMgr.Quiet()
 
-func NewFaktory(conf FaktoryConf) *faktoryClient {
-	if len(conf.Url) <= 0 {
-		return nil
+	abandoned := c.inflight.Wait(c._conf.Worker.ShutdownTimeout)
+	if c.cancel != nil {
+		c.cancel()
 	}
+	queue.ReportAbandoned(abandoned)
 
-	// FAKTORY_PROVIDER=FOO_URL
-	// FOO_URL=tcp://:mypassword@faktory.example.com:7419
-	if err := os.Setenv("FAKTORY_PROVIDER", "FOO_URL"); err != nil {
-		panic(err)
+	if c.terminated != nil {
+		select {
+		case <-c.terminated:
+		case <-time.After(c._conf.Worker.ShutdownTimeout):
+		}
 	}
-	if err := os.Setenv("FOO_URL", conf.Url); err != nil {
-		panic(err)
+}
+
+// NewFaktory builds a client from conf only, the process environment is left untouched,
+// so several clients for different servers can live in one process.
+func NewFaktory(conf FaktoryConf) (*faktoryClient, error) {
+	server, err := newServer(conf)
+	if err != nil {
+		return nil, err
 	}
 
 	// pool
-	pool, err := faktory.NewPool(conf.Sender.PoolCapacity)
+	pool, err := newClientPool(server, conf.Sender.PoolCapacity)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	// worker manager
-	workerMgr := worker.NewManager()
-	workerMgr.Concurrency = conf.Worker.Concurrency
-	workerMgr.ProcessWeightedPriorityQueues(conf.Worker.PullFromQueuesWithPriority)
+	// worker manager, the same pool capacity as the default pool of faktory_worker_go
+	workerPool, err := newClientPool(server, conf.Worker.Concurrency+2)
+	if err != nil {
+		return nil, err
+	}
+	workerMgr := newWorkerManager(workerPool, conf.Worker)
 
-	return &faktoryClient{
+	metrics := queue.NewMetrics("faktory")
+	_faktoryClient := &faktoryClient{
 		_conf:      conf,
 		senderPool: pool,
+		dlq:        newDlq(pool, conf.Dlq, metrics),
 		workerMgr:  workerMgr,
+		metrics:    metrics,
+		lifecycle:  newLifecycle(),
 	}
+	_faktoryClient.workflow = queue.NewWorkflow(_faktoryClient)
+	_faktoryClient.lifecycle.register(workerMgr)
+
+	// "Working on job" log, other middlewares may be registered by Use/UseFor
+	_faktoryClient.Use(queue.Logging())
+
+	return _faktoryClient, nil
+}
+
+func (c *faktoryClient) SetDeadLetterStore(store queue.DeadLetterStore) {
+	c.dlq.store = store
+}
+func (c *faktoryClient) DeadLetters() *queue.DeadLetters {
+	return queue.NewDeadLetters(c.dlq.store, c)
+}
+
+func (c *faktoryClient) SetGroupStore(store queue.GroupStore) {
+	c.workflow.SetGroupStore(store)
+}
+func (c *faktoryClient) PushGroup(ctx context.Context, callback *queue.Job, members ...*queue.Job) (string, error) {
+	return c.workflow.PushGroup(ctx, callback, members...)
+}
+
+func (c *faktoryClient) SetStatusStore(store *queue.StatusStore) {
+	c.status = store
 }
 
-func (c *faktoryClient) SetProcessor(jobNameProcessorMap map[string]JobProcessor) {
-	c.jobNameProcessorMap = jobNameProcessorMap
+func (c *faktoryClient) SetProcessor(jobNameProcessorMap map[string]queue.JobProcessor) {
+	c.jobNameProcessorMap = make(map[string]queue.ContextJobProcessor)
+	for jobName, processor := range jobNameProcessorMap {
+		c.RegisterContext(jobName, processor.WithContext())
+	}
+}
+func (c *faktoryClient) Register(jobType string, processor queue.JobProcessor) {
+	c.RegisterContext(jobType, processor.WithContext())
+}
+func (c *faktoryClient) RegisterContext(jobType string, processor queue.ContextJobProcessor) {
+	if c.jobNameProcessorMap == nil {
+		c.jobNameProcessorMap = make(map[string]queue.ContextJobProcessor)
+	}
+	c.jobNameProcessorMap[jobType] = processor
 }
 func (c *faktoryClient) Context() context.Context {
 	return c.ctx
 }
 
 // https://github.com/contribsys/faktory_worker_go#usage
-func (c *faktoryClient) processing(ctx context.Context, jobNameProcessorMap map[string]JobProcessor) {
+func (c *faktoryClient) processing(ctx context.Context, jobNameProcessorMap map[string]queue.ContextJobProcessor) {
 	c.ctx, c.cancel = context.WithCancel(ctx)
 
-	go func() {
-		// Start processing jobs in background routine, this method does not return
-		// unless an error is returned or cancel() is called
-		c.workerMgr.RunWithContext(c.ctx)
-	}()
+	// the job context carries the helper, it expires with the job's reservation,
+	// and it is cancelled when the client stops
+	c.workerMgr.Use(func(ctx context.Context, job *faktory.Job, next func(ctx context.Context) error) error {
+		inflightJob := fromFaktoryJob(job)
+		c.inflight.Add(inflightJob)
+		defer c.inflight.Done(inflightJob)
+
+		// the processor runs in the consumer span, a child of the span which pushed the job
+		help := &helper{Helper: workerHelperFor(ctx), job: inflightJob, status: c.status}
+		jobCtx, span := queue.StartJobSpan(ctx, inflightJob)
+		jobCtx, cancel := queue.JobContext(jobCtx, help, job.ReserveFor)
+		defer cancel()
+		stop := context.AfterFunc(c.ctx, cancel)
+		defer stop()
+
+		c.status.Running(inflightJob)
+		for _, hook := range c.beforeJob {
+			hook(jobCtx, inflightJob)
+		}
+		start := c.metrics.Started(inflightJob)
+		err := next(jobCtx)
+		c.metrics.Done(inflightJob, start, err)
+		queue.EndSpan(span, err)
+		for _, hook := range c.afterJob {
+			hook(jobCtx, inflightJob, err)
+		}
+
+		// the follow-ups are pushed in the trace of the job, jobs reprocessed from the dlq were followed up when they died
+		reprocessing := strings.HasSuffix(job.Queue, QUEUE_DLQ_SUFFIX)
+		workflowCtx := context.WithoutCancel(jobCtx)
+
+		if err == nil {
+			c.status.Succeeded(inflightJob)
+			if !reprocessing {
+				c.workflow.Succeeded(workflowCtx, inflightJob)
+			}
+			return nil
+		}
+
+		// over a limit, ack the job and push it again for later, this is not a failure
+		if delay, ok := queue.SnoozeDelay(err); ok {
+			c.status.Snoozed(inflightJob)
+			return c.snooze(inflightJob, delay)
+		}
+
+		// faktory schedules the retries, Fail only tells whether there will be one
+		retry, _ := inflightJob.Fail(err, nil)
+
+		// reprocessing failed, ack the job and park it, faktory retries it if it can not be parked
+		if reprocessing && c.dlq.store != nil {
+			c.status.Failed(inflightJob, err, true)
+			return c.dlq.Park(inflightJob)
+		}
+
+		c.status.Failed(inflightJob, err, !retry)
+		if !queue.IsDead(err) {
+			if retry {
+				c.metrics.Retried(inflightJob)
+				return err
+			}
+
+			// faktory discards the job or moves it to its dead set
+			if inflightJob.Discardable(err) {
+				c.metrics.Dead(inflightJob, queue.DeadActionDiscard)
+			}
+			if !reprocessing {
+				c.workflow.Dead(workflowCtx, inflightJob)
+			}
+			return err
+		}
+
+		// retrying would not help, ack the job and move it to the dlq
+		if err := c.dlq.RequeueDeadJob(inflightJob); err != nil {
+			return err
+		}
+		if !reprocessing {
+			c.workflow.Dead(workflowCtx, inflightJob)
+		}
+		return nil
+	})
+
+	// the queue middlewares run inside, for every job type, so Use/UseFor apply to the handlers registered on the manager too
+	c.workerMgr.Use(c.chain)
 
 	// register processor
 	for jobName, processor := range jobNameProcessorMap {
 		// register job processor one by one
-		newProcessor := processor
+		processor := processor
 		c.workerMgr.Register(
 			jobName,
 			func(ctx context.Context, args ...interface{}) error {
-				help := worker.HelperFor(ctx)
-				logx.Infof("Working on job %s\n", help.Jid())
-				return newProcessor(help, args...) // success then return nil as error, it will auto ack
+				return processor(ctx, queue.HelperFor(ctx), args...) // success then return nil as error, it will auto ack
 			},
 		)
 	}
+
+	// Start processing jobs in background routines, until cancel() is called
+	c.workerMgr.Run()
+	c.terminated = make(chan struct{})
+	go func() {
+		defer close(c.terminated)
+
+		<-c.ctx.Done()
+		c.workerMgr.Terminate(false)
+	}()
 	//
 	//go func() {
 	//	stopSignals := []os.Signal{
@@ -113,9 +274,70 @@ func (c *faktoryClient) processing(ctx context.Context, jobNameProcessorMap map[
 	//<-c.ctx.Done()
 }
 
-func (c *faktoryClient) Push(job *faktory.Job) error {
+func (c *faktoryClient) Push(job *queue.Job) error {
+	return c.PushCtx(context.Background(), job)
+}
+func (c *faktoryClient) PushCtx(ctx context.Context, job *queue.Job) (err error) {
+	_, span := queue.StartPushSpan(ctx, job)
+	defer func() {
+		c.metrics.Push(job.Queue, err)
+		queue.EndSpan(span, err)
+	}()
+
+	pushedAt := time.Now()
+	err = c.senderPool.With(func(cl *faktory.Client) error {
+		// job := queue.NewJob("SomeJob", 1, 2, 3)
+		return cl.Push(toFaktoryJob(job))
+	})
+	if err == nil {
+		c.status.Enqueued(job, pushedAt)
+	}
+	return err
+}
+
+// PushBulk pushes jobs by one PUSHB
+func (c *faktoryClient) PushBulk(jobs []*queue.Job) (map[string]error, error) {
+	// every job gets its push span, injected before the job is converted
+	spans := make([]trace.Span, len(jobs))
+	fJobs := make([]*faktory.Job, 0, len(jobs))
+	for i, job := range jobs {
+		_, spans[i] = queue.StartPushSpan(context.Background(), job)
+		fJobs = append(fJobs, toFaktoryJob(job))
+	}
+
+	pushedAt := time.Now()
+	failed := make(map[string]error)
+	err := c.senderPool.With(func(cl *faktory.Client) error {
+		results, err := cl.PushBulk(fJobs)
+		if err != nil {
+			return err
+		}
+		for jid, message := range results {
+			failed[jid] = errors.New(message)
+		}
+		return nil
+	})
+	for i, job := range jobs {
+		jobErr := err
+		if err == nil {
+			jobErr = failed[job.Jid]
+		}
+		c.metrics.Push(job.Queue, jobErr)
+		queue.EndSpan(spans[i], jobErr)
+		if jobErr == nil {
+			c.status.Enqueued(job, pushedAt)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return failed, nil
+}
+
+// snooze pushes job again to run after delay, faktory retries the job if it can not be pushed
+func (c *faktoryClient) snooze(job *queue.Job, delay time.Duration) error {
+	job.At = time.Now().Add(delay).UTC().Format(time.RFC3339Nano)
 	return c.senderPool.With(func(cl *faktory.Client) error {
-		// job := faktory.NewJob("SomeJob", 1, 2, 3)
-		return cl.Push(job)
+		return cl.Push(toFaktoryJob(job))
 	})
 }
@@ -0,0 +1,305 @@
+package faktory
+
+import (
+	"context"
+	"encoding/json"
+	faktory "github.com/contribsys/faktory/client"
+	worker "github.com/contribsys/faktory_worker_go"
+	"github.com/zeromicro/go-zero/core/logx"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the events of the worker manager
+type workerEvent int
+
+const (
+	workerStartup workerEvent = iota
+	workerQuiet
+	workerShutdown
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	reportTimeout     = 30 * time.Second // how long the result of a job is retried
+	maxWorkerBackoff  = 30 * time.Second
+)
+
+// workerManager fetches and runs the jobs as worker.Manager of faktory_worker_go does, over the connections
+// of a clientPool: worker.Manager only takes a *faktory.Pool, whose clients are opened from the environment.
+type workerManager struct {
+	pool        *clientPool
+	concurrency int
+	queues      []string // every queue as many times as its priority
+	middleware  []worker.MiddlewareFunc
+	handlers    map[string]worker.Handler
+	hooks       map[workerEvent][]func() error
+	contacted   func(err error) // called with the result of every fetch and heartbeat, if set
+
+	mu      sync.Mutex
+	state   string // "", "quiet" or "terminate", as in the heartbeat
+	done    chan struct{}
+	running sync.WaitGroup
+}
+
+func newWorkerManager(pool *clientPool, conf WorkerConf) *workerManager {
+	m := &workerManager{
+		pool:        pool,
+		concurrency: conf.Concurrency,
+		handlers:    make(map[string]worker.Handler),
+		hooks:       make(map[workerEvent][]func() error),
+		done:        make(chan struct{}),
+	}
+	for queue, priority := range conf.PullFromQueuesWithPriority {
+		for i := 0; i < priority; i++ {
+			m.queues = append(m.queues, queue)
+		}
+	}
+	if len(m.queues) <= 0 {
+		m.queues = []string{"default"}
+	}
+	return m
+}
+
+// On registers fn to run on event, the hooks must be registered before Run
+func (m *workerManager) On(event workerEvent, fn func() error) {
+	m.hooks[event] = append(m.hooks[event], fn)
+}
+
+// Use adds middleware to the chain of every job
+func (m *workerManager) Use(middleware ...worker.MiddlewareFunc) {
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// Register runs the jobs of jobType by fn
+func (m *workerManager) Register(jobType string, fn worker.Perform) {
+	m.handlers[jobType] = func(ctx context.Context, job *faktory.Job) error {
+		return fn(ctx, job.Args...)
+	}
+}
+
+// Run starts fetching jobs until Quiet or Terminate
+func (m *workerManager) Run() {
+	// all the connections of the process are worker connections from now on
+	if len(faktory.RandomProcessWid) <= 0 {
+		faktory.RandomProcessWid = strconv.FormatInt(rand.Int63(), 32)
+	}
+
+	m.fire(workerStartup)
+	m.running.Add(m.concurrency + 1)
+	go m.heartbeat()
+	for i := 0; i < m.concurrency; i++ {
+		go m.process()
+	}
+}
+
+// Quiet stops fetching jobs, the running ones finish
+func (m *workerManager) Quiet() {
+	m.mu.Lock()
+	if len(m.state) > 0 {
+		m.mu.Unlock()
+		return
+	}
+	m.state = "quiet"
+	m.mu.Unlock()
+
+	m.fire(workerQuiet)
+}
+
+// Terminate stops fetching jobs and waits for the running ones, exit ends the process then
+func (m *workerManager) Terminate(exit bool) {
+	m.mu.Lock()
+	if m.state == "terminate" {
+		m.mu.Unlock()
+		return
+	}
+	m.state = "terminate"
+	close(m.done)
+	m.mu.Unlock()
+
+	m.fire(workerShutdown)
+	m.running.Wait()
+	m.pool.Close()
+	if exit {
+		os.Exit(0)
+	}
+}
+
+func (m *workerManager) currentState() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *workerManager) contact(err error) {
+	if m.contacted != nil {
+		m.contacted(err)
+	}
+}
+
+func (m *workerManager) fire(event workerEvent) {
+	for _, fn := range m.hooks[event] {
+		if err := fn(); err != nil {
+			logx.Errorf("go-zero-utils: faktory worker hook: %v", err)
+		}
+	}
+}
+
+// heartbeat tells the server the worker is alive, the server answers quiet or terminate to stop it
+func (m *workerManager) heartbeat() {
+	defer m.running.Done()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			err := m.beat()
+			m.contact(err)
+			if err != nil {
+				logx.Errorf("go-zero-utils: faktory heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+func (m *workerManager) beat() error {
+	return m.pool.With(func(cl *faktory.Client) error {
+		data, err := cl.Beat(m.currentState())
+		if err != nil && strings.Contains(err.Error(), "Unknown worker") {
+			// the heartbeat expired, the process must restart to register again, the service unwinds on SIGTERM
+			logx.Error("go-zero-utils: faktory heartbeat expired, shutting down")
+			if process, err := os.FindProcess(os.Getpid()); err == nil {
+				_ = process.Signal(syscall.SIGTERM)
+			}
+		}
+		if err != nil || len(data) <= 0 {
+			return err
+		}
+
+		var hash map[string]string
+		if err := json.Unmarshal([]byte(data), &hash); err != nil {
+			return err
+		}
+		switch hash["state"] {
+		case "quiet":
+			go m.Quiet()
+		case "terminate":
+			go m.Terminate(true)
+		}
+		return nil
+	})
+}
+
+// process runs jobs one at a time until the worker is quiet
+func (m *workerManager) process() {
+	defer m.running.Done()
+
+	backoff := time.Second
+	for len(m.currentState()) <= 0 {
+		err := m.processOne()
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+
+		logx.Errorf("go-zero-utils: faktory worker: %v", err)
+		select {
+		case <-m.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxWorkerBackoff {
+			backoff = maxWorkerBackoff
+		}
+	}
+}
+
+// processOne fetches a job and runs it, the server blocks a few seconds if there is none
+func (m *workerManager) processOne() error {
+	var job *faktory.Job
+	err := m.pool.With(func(cl *faktory.Client) (err error) {
+		job, err = cl.Fetch(m.queueList()...)
+		return err
+	})
+	m.contact(err)
+	if err != nil || job == nil {
+		return err
+	}
+
+	var jobErr error
+	if perform, ok := m.handlers[job.Type]; ok {
+		ctx := context.WithValue(context.Background(), jobHelperKey{}, &jobHelper{job: job, pool: m.pool})
+		jobErr = dispatch(m.middleware, ctx, job, perform)
+	} else {
+		jobErr = &worker.NoHandlerError{JobType: job.Type}
+	}
+
+	m.report(job, jobErr)
+	return nil
+}
+
+// report acks or fails job, it is retried for reportTimeout, then the server retries the job once its reservation expired
+func (m *workerManager) report(job *faktory.Job, jobErr error) {
+	deadline := time.Now().Add(reportTimeout)
+	backoff := time.Second
+	for {
+		err := m.pool.With(func(cl *faktory.Client) error {
+			if jobErr != nil {
+				return cl.Fail(job.Jid, jobErr, nil)
+			}
+			return cl.Ack(job.Jid)
+		})
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			logx.Errorf("go-zero-utils: faktory report job %s: %v", job.Jid, err)
+			return
+		}
+
+		select {
+		case <-m.done:
+			logx.Errorf("go-zero-utils: faktory report job %s: %v", job.Jid, err)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxWorkerBackoff {
+			backoff = maxWorkerBackoff
+		}
+	}
+}
+
+// queueList returns the queues to fetch from, in a random order weighted by their priority
+func (m *workerManager) queueList() []string {
+	weighted := append([]string(nil), m.queues...)
+	rand.Shuffle(len(weighted), func(i, j int) {
+		weighted[i], weighted[j] = weighted[j], weighted[i]
+	})
+
+	seen := make(map[string]bool)
+	queues := make([]string, 0, len(weighted))
+	for _, queue := range weighted {
+		if !seen[queue] {
+			seen[queue] = true
+			queues = append(queues, queue)
+		}
+	}
+	return queues
+}
+
+func dispatch(chain []worker.MiddlewareFunc, ctx context.Context, job *faktory.Job, perform worker.Handler) error {
+	if len(chain) <= 0 {
+		return perform(ctx, job)
+	}
+	return chain[0](ctx, job, func(ctx context.Context) error {
+		return dispatch(chain[1:], ctx, job, perform)
+	})
+}
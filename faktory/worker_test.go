@@ -0,0 +1,69 @@
+package faktory
+
+import (
+	"context"
+	"errors"
+	"github.com/toby1991/go-zero-utils/faktory/faktorytest"
+	"github.com/toby1991/go-zero-utils/queue"
+	"testing"
+	"time"
+)
+
+func Test_workerManager(t *testing.T) {
+	server := faktorytest.NewServer()
+	t.Cleanup(server.Close)
+
+	// the worker connections are built from the config only, an invalid environment is ignored
+	t.Setenv("FAKTORY_PROVIDER", "tcp://:wrong@127.0.0.1:1")
+
+	c, err := NewFaktory(FaktoryConf{
+		Url:     server.Url(),
+		Timeout: time.Second,
+		Sender:  SenderConf{PoolCapacity: 1},
+		Worker:  WorkerConf{Concurrency: 2, PullFromQueuesWithPriority: map[string]int{"default": 1}, ShutdownTimeout: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan string, 2)
+	c.RegisterContext("sync_goods", func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+		ran <- helper.Jid()
+		if len(args) > 0 && args[0] == "boom" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	c.Start()
+	defer c.Stop()
+
+	ok, failed := queue.NewJob("sync_goods", "ok"), queue.NewJob("sync_goods", "boom")
+	for _, job := range []*queue.Job{ok, failed} {
+		if err := c.Push(job); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the jobs were not run")
+		}
+	}
+
+	if !c.Ready() {
+		t.Error("not ready once jobs were fetched")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Queued()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queued := server.Queued(); len(queued) != 0 {
+		t.Errorf("queued = %d jobs, want all acked or failed", len(queued))
+	}
+	failures := server.Failures()
+	if len(failures) != 1 || failures[failed.Jid] != "boom" {
+		t.Errorf("failures = %v, want %s failed by boom", failures, failed.Jid)
+	}
+}
@@ -0,0 +1,203 @@
+package nsq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/cacher"
+	"github.com/toby1991/go-zero-utils/queue"
+	"github.com/zeromicro/go-zero/core/logx"
+	"time"
+)
+
+const (
+	batchKeyPrefix  = "nsq:batch:"
+	batchExpiration = 30 * 24 * time.Hour // the same as faktory enterprise
+)
+
+var ErrBatchNotFound = errors.New("nsq: batch not found")
+
+// batchDoneScript updates the counters of a batch, and returns the callback jobs to push
+// once every job of a committed batch is done, callbacks are returned only once.
+//
+// ARGV[1] pending delta, ARGV[2] failed delta, ARGV[3] "1" to commit the batch
+var batchDoneScript = bizredis.NewScript(`if redis.call("EXISTS", KEYS[1]) == 0 then
+    return {}
+end
+local pending = redis.call("HINCRBY", KEYS[1], "pending", ARGV[1])
+local failed = redis.call("HINCRBY", KEYS[1], "failed", ARGV[2])
+if ARGV[3] == "1" then
+    redis.call("HSET", KEYS[1], "committed", "1")
+end
+if pending > 0 or redis.call("HGET", KEYS[1], "committed") ~= "1" or redis.call("HSETNX", KEYS[1], "fired", "1") == 0 then
+    return {}
+end
+local callbacks = {}
+local complete = redis.call("HGET", KEYS[1], "complete")
+if complete then
+    table.insert(callbacks, complete)
+end
+local success = redis.call("HGET", KEYS[1], "success")
+if success and failed == 0 then
+    table.insert(callbacks, success)
+end
+return callbacks`)
+
+// Batch groups jobs under a BID, like faktory enterprise batches, its state is tracked in redis.
+//
+//	b := client.NewBatch()
+//	b.Success = queue.NewJob("kline_filled", siteId)
+//	err := b.Jobs(func() error {
+//	    return b.Push(queue.NewJob("kline_filling", goodsId))
+//	})
+type Batch struct {
+	Bid         string
+	Description string
+	Success     *queue.Job // pushed once every job of the batch succeeded
+	Complete    *queue.Job // pushed once every job of the batch succeeded or died
+
+	client    *nsqClient
+	committed bool
+}
+
+// NewBatch creates a batch, it is saved to redis by Jobs
+func (c *nsqClient) NewBatch() *Batch {
+	return &Batch{Bid: "b-" + queue.RandomJid(), client: c}
+}
+
+// OpenBatch reopens a committed batch to add jobs to it, usually from a job of the batch
+func (c *nsqClient) OpenBatch(ctx context.Context, bid string) (*Batch, error) {
+	if c.redis == nil {
+		return nil, ErrRedisRequired
+	}
+
+	description, err := c.redis.Client().HGet(ctx, c.key(c.batchKey(bid)), "description").Result()
+	if err != nil {
+		return nil, ErrBatchNotFound
+	}
+
+	return &Batch{Bid: bid, Description: description, client: c, committed: true}, nil
+}
+
+// Jobs saves a new batch, runs fn to push its jobs, then commits the batch:
+// callbacks are not pushed before the batch is committed, so a batch whose fn failed never completes.
+func (b *Batch) Jobs(fn func() error) error {
+	if b.client.redis == nil {
+		return ErrRedisRequired
+	}
+
+	ctx := context.Background()
+	if !b.committed {
+		if err := b.save(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	b.committed = true
+	return b.client.batchDone(ctx, b.Bid, 0, 0, true)
+}
+
+// Push pushes job as a member of the batch
+func (b *Batch) Push(job *queue.Job) error {
+	if b.client.redis == nil {
+		return ErrRedisRequired
+	}
+
+	ctx := context.Background()
+	key := b.client.key(b.client.batchKey(b.Bid))
+	if err := b.client.redis.Client().HIncrBy(ctx, key, "pending", 1).Err(); err != nil {
+		return err
+	}
+
+	job.SetCustom("bid", b.Bid)
+	if err := b.client.Push(job); err != nil {
+		if err := b.client.batchDone(ctx, b.Bid, -1, 0, false); err != nil {
+			logx.Errorf("go-zero-utils: batch %s: %v", b.Bid, err)
+		}
+		return err
+	}
+
+	return b.client.redis.Client().HIncrBy(ctx, key, "total", 1).Err()
+}
+
+func (b *Batch) save(ctx context.Context) error {
+	fields := map[string]interface{}{
+		"description": b.Description,
+		"created_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for field, job := range map[string]*queue.Job{"success": b.Success, "complete": b.Complete} {
+		if job == nil {
+			continue
+		}
+
+		job.SetCustom("_bid", b.Bid)
+		jobJsonBytes, err := job.JsonBytes()
+		if err != nil {
+			return err
+		}
+		fields[field] = jobJsonBytes
+	}
+
+	key := b.client.key(b.client.batchKey(b.Bid))
+	pipe := b.client.redis.Client().TxPipeline()
+	pipe.HSet(ctx, key, fields)
+	pipe.Expire(ctx, key, batchExpiration)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *nsqClient) batchKey(bid string) string {
+	return batchKeyPrefix + bid
+}
+
+// key prefixes raw for the redis client, scripts are prefixed by bizredis
+func (c *nsqClient) key(raw string) string {
+	return cacher.NewKey(raw, c.redis.Prefix()).Prefixed()
+}
+
+// jobDone is called once a job finished for good, dead is true if it failed
+func (c *nsqClient) jobDone(ctx context.Context, job *queue.Job, dead bool) {
+	bid, _ := job.GetCustom("bid")
+	if b, ok := bid.(string); ok && len(b) > 0 && c.redis != nil {
+		failed := 0
+		if dead {
+			failed = 1
+		}
+		if err := c.batchDone(ctx, b, -1, failed, false); err != nil {
+			logx.Errorf("go-zero-utils: batch %s: %v", b, err)
+		}
+	}
+}
+
+// batchDone updates the counters of the batch, and pushes its callbacks once all its jobs are done
+func (c *nsqClient) batchDone(ctx context.Context, bid string, pending, failed int, commit bool) error {
+	committed := "0"
+	if commit {
+		committed = "1"
+	}
+
+	resp, err := c.redis.ScriptRunCtx(ctx, batchDoneScript, []string{c.batchKey(bid)}, pending, failed, committed)
+	if err != nil {
+		return err
+	}
+
+	callbacks, _ := resp.([]interface{})
+	for _, callback := range callbacks {
+		callbackJson, _ := callback.(string)
+
+		var job queue.Job
+		if err := json.Unmarshal([]byte(callbackJson), &job); err != nil {
+			return err
+		}
+		if err := c.Push(&job); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package nsq
+
+import (
+	"context"
+	"github.com/toby1991/go-zero-utils/queue"
+	"testing"
+)
+
+func Test_Batch_callbacks(t *testing.T) {
+	tests := []struct {
+		name  string
+		dead  bool
+		wantC []string // the callbacks pushed once both jobs are done
+	}{
+		{name: "all succeeded", wantC: []string{"goods_complete", "goods_success"}},
+		{name: "one died", dead: true, wantC: []string{"goods_complete"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, nsqd, _ := newTestNsq(t, NsqConf{})
+
+			b := c.NewBatch()
+			b.Success = queue.NewJob("goods_success")
+			b.Complete = queue.NewJob("goods_complete")
+			err := b.Jobs(func() error {
+				if err := b.Push(queue.NewJob("sync_goods", 1)); err != nil {
+					return err
+				}
+				return b.Push(queue.NewJob("sync_goods", 2))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jobs := nsqd.jobs("default")
+			if len(jobs) != 2 {
+				t.Fatalf("published %d jobs, want the 2 jobs of the batch", len(jobs))
+			}
+			for i, job := range jobs {
+				if err := handle(t, c, job, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+					if tt.dead && i == 0 {
+						return queue.Dead(context.Canceled)
+					}
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				if callbacks := len(nsqd.jobs("default")) - len(jobs); i == 0 && callbacks != 0 {
+					t.Fatalf("pushed %d callbacks while a job of the batch is pending", callbacks)
+				}
+			}
+
+			var callbacks []string
+			for _, job := range nsqd.jobs("default")[len(jobs):] {
+				callbacks = append(callbacks, job.Type)
+			}
+			if len(callbacks) != len(tt.wantC) {
+				t.Fatalf("callbacks = %v, want %v", callbacks, tt.wantC)
+			}
+			for i := range callbacks {
+				if callbacks[i] != tt.wantC[i] {
+					t.Errorf("callbacks = %v, want %v", callbacks, tt.wantC)
+				}
+			}
+		})
+	}
+}
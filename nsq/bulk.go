@@ -0,0 +1,124 @@
+package nsq
+
+import (
+	"context"
+	"github.com/toby1991/go-zero-utils/queue"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// maxMultiPublish bounds the messages of a MPUB, so it stays under nsqd's --max-body-size
+const maxMultiPublish = 500
+
+// bulkGroup is the jobs of a PushBulk published together: same topic and same At
+type bulkGroup struct {
+	topic  string
+	at     string // "" for the jobs due now
+	delay  time.Duration
+	jobs   []*queue.Job
+	bodies [][]byte
+}
+
+// PushBulk groups jobs by topic: the jobs due now are published by MPUB, the deferred ones
+// are grouped by At as well and their DPUBs pipelined, those over Scheduler.MaxDeferredDelay go to the redis scheduler.
+func (c *nsqClient) PushBulk(jobs []*queue.Job) (map[string]error, error) {
+	ctx := context.Background()
+	failed := make(map[string]error)
+
+	// every job gets its push span, injected before the job is marshalled
+	spans := make([]trace.Span, len(jobs))
+	for i, job := range jobs {
+		_, spans[i] = queue.StartPushSpan(ctx, job)
+	}
+
+	groups := make([]*bulkGroup, 0)
+	groupIndex := make(map[[2]string]*bulkGroup)
+	for _, job := range jobs {
+		delay, err := jobDelay(job)
+		if err != nil {
+			failed[job.Jid] = err
+			continue
+		}
+
+		// rejects duplicates within the unique_for window, the lock caches the unique key in the job so it is marshalled after
+		if err := c.unique.lock(ctx, job); err != nil {
+			failed[job.Jid] = err
+			continue
+		}
+
+		jobJsonBytes, err := job.JsonBytes()
+		if err != nil {
+			failed[job.Jid] = err
+			c.unique.unlock(ctx, job)
+			continue
+		}
+
+		at := job.At
+		if delay <= 0 {
+			at, delay = "", 0
+		}
+		group, ok := groupIndex[[2]string{job.Queue, at}]
+		if !ok {
+			group = &bulkGroup{topic: job.Queue, at: at, delay: delay}
+			groupIndex[[2]string{job.Queue, at}] = group
+			groups = append(groups, group)
+		}
+		group.jobs = append(group.jobs, job)
+		group.bodies = append(group.bodies, jobJsonBytes)
+	}
+
+	for _, group := range groups {
+		pushedAt := time.Now()
+		for i, err := range c.publishGroup(ctx, group) {
+			job := group.jobs[i]
+			if err != nil {
+				failed[job.Jid] = err
+				c.unique.unlock(ctx, job)
+				continue
+			}
+			c.status.Enqueued(job, pushedAt)
+		}
+	}
+
+	for i, job := range jobs {
+		c.metrics.Push(job.Queue, failed[job.Jid])
+		queue.EndSpan(spans[i], failed[job.Jid])
+	}
+
+	if len(jobs) > 0 && len(failed) == len(jobs) {
+		for _, err := range failed {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// publishGroup returns the error of every job of group
+func (c *nsqClient) publishGroup(ctx context.Context, group *bulkGroup) []error {
+	errs := make([]error, len(group.jobs))
+
+	switch {
+	case group.delay <= 0:
+		for start := 0; start < len(group.bodies); start += maxMultiPublish {
+			end := start + maxMultiPublish
+			if end > len(group.bodies) {
+				end = len(group.bodies)
+			}
+
+			if err := c.senderPool.MultiPublish(group.topic, group.bodies[start:end]); err != nil {
+				for i := start; i < end; i++ {
+					errs[i] = err
+				}
+			}
+		}
+	case c.scheduler != nil && group.delay > c._conf.Scheduler.MaxDeferredDelay:
+		at := time.Now().Add(group.delay)
+		for i, body := range group.bodies {
+			errs[i] = c.scheduler.Schedule(ctx, group.topic, at, body)
+		}
+	default:
+		errs = c.senderPool.DeferredMultiPublish(group.topic, group.delay, group.bodies)
+	}
+
+	return errs
+}
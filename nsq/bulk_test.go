@@ -0,0 +1,84 @@
+package nsq
+
+import (
+	"context"
+	"github.com/toby1991/go-zero-utils/bizmemory"
+	"github.com/toby1991/go-zero-utils/queue"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func Test_nsqClient_PushBulk(t *testing.T) {
+	c, nsqd, _ := newTestNsq(t, NsqConf{})
+	nsqd.reject("broken")
+	c.SetStatusStore(queue.NewStatusStore(bizmemory.NewMemory(bizmemory.BizMemoryConf{DefaultExpirationMinute: 1, CleanUpIntervalMinute: 1}), 0))
+
+	pushed := queue.NewJob("sync_goods", 1).SetUniqueFor(60)
+	lost := queue.NewJob("sync_goods", 2).SetUniqueFor(60)
+	lost.Queue = "broken"
+
+	failed, err := c.PushBulk([]*queue.Job{pushed, lost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[lost.Jid] == nil {
+		t.Fatalf("failed = %v, want the job of the rejected topic", failed)
+	}
+
+	// the published job carries its unique key, so the worker releases the same lock
+	jobs := nsqd.jobs("default")
+	if len(jobs) != 1 {
+		t.Fatalf("published %d jobs, want 1", len(jobs))
+	}
+	if key, ok := jobs[0].GetCustom("unique_key"); !ok || key == "" {
+		t.Errorf("published job without its unique key")
+	}
+	if _, err := c.status.Get(pushed.Jid); err != nil {
+		t.Errorf("status of the published job error = %v", err)
+	}
+
+	// the failed job is neither locked nor enqueued
+	if _, err := c.status.Get(lost.Jid); err != queue.ErrStatusNotFound {
+		t.Errorf("status of the failed job error = %v, want ErrStatusNotFound", err)
+	}
+	retry := queue.NewJob("sync_goods", 2).SetUniqueFor(60)
+	retry.Queue = "broken"
+	if err := c.unique.lock(context.Background(), retry); err != nil {
+		t.Errorf("lock of the failed job error = %v, want it released", err)
+	}
+}
+
+func Test_nsqClient_PushBulk_trace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	c, nsqd, _ := newTestNsq(t, NsqConf{})
+	nsqd.reject("broken")
+
+	lost := queue.NewJob("sync_goods", 2)
+	lost.Queue = "broken"
+	if _, err := c.PushBulk([]*queue.Job{queue.NewJob("sync_goods", 1), lost}); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := nsqd.jobs("default")
+	if len(jobs) != 1 {
+		t.Fatalf("published %d jobs, want 1", len(jobs))
+	}
+	if _, ok := jobs[0].GetCustom("traceparent"); !ok {
+		t.Errorf("traceparent not injected, custom = %v", jobs[0].Custom)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("ended %d spans, want a push span per job", len(spans))
+	}
+	if spans[0].Status().Description != "" || spans[1].Status().Description == "" {
+		t.Errorf("span statuses = %v, %v, want the failed push recorded", spans[0].Status(), spans[1].Status())
+	}
+}
@@ -1,13 +1,31 @@
 package nsq
 
+import (
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/queue"
+	"time"
+)
+
 // docker exec -it faktory_container_name redis-cli -s /var/lib/faktory/db/redis.sock
 type NsqConf struct {
 	Sender SenderConf
 	Worker WorkerConf
+
+	// Redis backs the features nsq does not provide, e.g. unique jobs
+	Redis     bizredis.BizRedisConf `json:",optional"`
+	Scheduler SchedulerConf
+
+	// Dlq parks dead jobs in redis by default, set another store with SetDeadLetterStore
+	Dlq queue.DlqConf
 }
 
 type SenderConf struct {
 	NsqdAddrs []string // []string{"127.0.0.1:4150"}
+
+	// nsqd registered in nsqlookupd are added to / removed from the pool at runtime
+	NsqLookupdAddrs   []string      `json:",optional"` // []string{"127.0.0.1:4161"}
+	DiscoveryInterval time.Duration `json:",default=30s"`
+	ProbeInterval     time.Duration `json:",default=5s"` // how often unhealthy nsqd are pinged
 }
 
 type WorkerConf struct {
@@ -15,5 +33,24 @@ type WorkerConf struct {
 
 	MaxInFlight int `json:",default=50"`
 
+	// the same as faktory, Concurrency workers are shared by the topics, a free worker picks
+	// a topic with waiting messages at random in proportion to its weight
+	Concurrency                int            `json:",default=20"`
 	PullFromQueuesWithPriority map[string]int `json:",default={\"default\":1}"` // {"critical":3, "default":2, "bulk":1}
+
+	RetryBackoff    time.Duration `json:",default=15s"` // first retry delay, doubled on every retry
+	MaxRetryBackoff time.Duration `json:",default=1h"`  // nsqd rejects delays over its --max-req-timeout (default 1h)
+
+	ShutdownTimeout time.Duration `json:",default=30s"` // how long Stop waits for in-flight jobs
+
+	// the jobs of the same ordering key run one at a time, see queue.Job.SetOrderingKey
+	Ordering queue.OrderingConf
+}
+
+// SchedulerConf only applies when Redis is configured, see scheduler
+type SchedulerConf struct {
+	MaxDeferredDelay time.Duration `json:",default=50m"` // longer delays are stored in redis, keep it under nsqd's --max-req-timeout
+	Precision        time.Duration `json:",default=1s"`  // how often redis is polled for due jobs
+	BatchSize        int           `json:",default=100"`
+	Lease            time.Duration `json:",default=1m"` // a claimed job not published within the lease is claimed again
 }
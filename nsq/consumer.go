@@ -2,7 +2,6 @@ package nsq
 
 import (
 	"github.com/nsqio/go-nsq"
-	"github.com/toby1991/go-zero-utils/queue"
 )
 
 type ConsumerPool struct {
@@ -19,7 +18,7 @@ func newConsumerPool(nsqLookupdHttpAddresses []string, conf *nsq.Config) *Consum
 	}
 }
 
-func (c *ConsumerPool) RegisterProcessor(topic string, channel string, processor queue.JobProcessor, concurrency int, dlq queue.Dlqer) error {
+func (c *ConsumerPool) RegisterHandler(topic string, channel string, handler nsq.Handler, concurrency int) error {
 	consumer, err := nsq.NewConsumer(topic, channel, c.conf)
 	if err != nil {
 		return err
@@ -30,7 +29,7 @@ func (c *ConsumerPool) RegisterProcessor(topic string, channel string, processor
 	if concurrency <= 0 {
 		concurrency = 1
 	}
-	consumer.AddConcurrentHandlers(newMessageHandler(processor, dlq), concurrency)
+	consumer.AddConcurrentHandlers(handler, concurrency)
 
 	// Use nsqlookupd to discover nsqd instances.
 	// See also ConnectToNSQD, ConnectToNSQDs, ConnectToNSQLookupds.
@@ -1,22 +1,45 @@
 package nsq
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/toby1991/go-zero-utils/queue"
 	"github.com/zeromicro/go-zero/core/logx"
+	"sync"
+	"time"
 )
 
 const TOPIC_DLQ_SUFFIX = "-dlq"
 
+// ErrDlqFlushed is returned for the jobs dying after the shutdown flushed the dlq, nsq redelivers their message
+var ErrDlqFlushed = errors.New("nsq: dlq is flushed")
+
 type dlq struct {
 	producerPool *ProducerPool
+	conf         queue.DlqConf
+	store        queue.DeadLetterStore // nil if neither redis nor a store is configured
+	metrics      *queue.Metrics
+
+	mu      sync.Mutex // guards flushed, no publish is added to pending once Flush waits for it
+	flushed bool
+	pending sync.WaitGroup
 }
 
-func newDlq(producerPool *ProducerPool) *dlq {
-	return &dlq{producerPool: producerPool}
+func newDlq(producerPool *ProducerPool, conf queue.DlqConf, store queue.DeadLetterStore, metrics *queue.Metrics) *dlq {
+	return &dlq{producerPool: producerPool, conf: conf, store: store, metrics: metrics}
 }
 
 func (d *dlq) RequeueDeadJob(job *queue.Job) error {
+	d.mu.Lock()
+	if d.flushed {
+		d.mu.Unlock()
+		return ErrDlqFlushed
+	}
+	d.pending.Add(1)
+	d.mu.Unlock()
+	defer d.pending.Done()
+
 	jobJsonBytes, err := job.JsonBytes()
 	if err != nil {
 		return err
@@ -24,5 +47,50 @@ func (d *dlq) RequeueDeadJob(job *queue.Job) error {
 
 	logx.Alert(fmt.Sprintf("go-zero-utils: RequeueDeadJob: %s", string(jobJsonBytes)))
 
-	return d.producerPool.Publish(job.Queue+TOPIC_DLQ_SUFFIX, 0, jobJsonBytes)
+	if d.conf.ModeFor(job.Queue) == queue.DlqPark {
+		err := d.Park(job)
+		if err == nil {
+			return nil
+		}
+		logx.Error("dlq park error, fallback to the dlq topic: ", err)
+	}
+
+	if err := d.producerPool.Publish(job.Queue+TOPIC_DLQ_SUFFIX, 0, jobJsonBytes); err != nil {
+		return err
+	}
+	d.metrics.Dead(job, queue.DeadActionDlq)
+	return nil
+}
+
+// Park stores the dead job until it is replayed or purged
+func (d *dlq) Park(job *queue.Job) error {
+	if d.store == nil {
+		return queue.ErrNoDeadLetterStore
+	}
+
+	logx.Infof("go-zero-utils: park dead job %s", job.Jid)
+	if err := d.store.Add(context.Background(), job); err != nil {
+		return err
+	}
+	d.metrics.Dead(job, queue.DeadActionPark)
+	return nil
+}
+
+// Flush waits for pending publishes to the dlq, up to timeout, the later ones fail with ErrDlqFlushed
+func (d *dlq) Flush(timeout time.Duration) {
+	d.mu.Lock()
+	d.flushed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logx.Error("go-zero-utils: timeout flushing dlq publishes")
+	}
 }
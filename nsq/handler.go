@@ -1,17 +1,28 @@
 package nsq
 
 import (
+	"context"
+	"errors"
 	"github.com/nsqio/go-nsq"
 	"github.com/toby1991/go-zero-utils/queue"
 	"github.com/zeromicro/go-zero/core/logx"
+	"strings"
+	"time"
 )
 
+const touchInterval = 20 * time.Second
+
 type messageHandler struct {
-	processor queue.JobProcessor
-	dlq       queue.Dlqer
+	client    *nsqClient
+	topic     string
+	jobType   string // Channel = job.Type
+	processor queue.ContextJobProcessor
 }
 
 // go/pkg/mod/github.com/nsqio/go-nsq@v1.1.0/consumer.go:1175
+//
+// retries are handled by HandleMessage, this is only reached when the job
+// could not be requeued, e.g. nsqd was unreachable MaxAttempts times.
 func (m *messageHandler) LogFailedMessage(message *nsq.Message) {
 	help, err := HelperFor(message)
 	if err != nil {
@@ -19,14 +30,15 @@ func (m *messageHandler) LogFailedMessage(message *nsq.Message) {
 		return
 	}
 
-	if err := m.dlq.RequeueDeadJob(help.Job()); err != nil {
+	if err := m.client.dlq.RequeueDeadJob(help.Job()); err != nil {
 		logx.Error("dlq error: ", err)
 		return
 	}
+	m.client.unique.unlock(context.Background(), help.Job())
 }
 
-func newMessageHandler(processor queue.JobProcessor, dlq queue.Dlqer) *messageHandler {
-	return &messageHandler{processor: processor, dlq: dlq}
+func newMessageHandler(client *nsqClient, topic string, jobType string, processor queue.ContextJobProcessor) *messageHandler {
+	return &messageHandler{client: client, topic: topic, jobType: jobType, processor: processor}
 }
 
 func (m *messageHandler) HandleMessage(message *nsq.Message) error {
@@ -34,8 +46,195 @@ func (m *messageHandler) HandleMessage(message *nsq.Message) error {
 	if err != nil {
 		return err
 	}
+	help.client = m.client
+
+	// every channel of a topic receives a copy of the message, only process our own job type
+	if help.JobType() != m.jobType {
+		if !m.client.handles(strings.TrimSuffix(m.topic, TOPIC_DLQ_SUFFIX), help.JobType()) {
+			logx.Errorf("go-zero-utils: no channel of topic %s handles job %s of type %s, the channel must be the job type",
+				m.topic, help.Jid(), help.JobType())
+		}
+		return nil
+	}
+
+	// wait for a worker of the budget shared by all topics
+	err = m.client.prioritizer.submit(m.topic, message, func() error {
+		// then for the jobs of the key, they already hold a worker so they do not wait for the one we hold
+		if key := help.Job().OrderingKey(); m.client.sequencer != nil && len(key) > 0 {
+			unlock, err := m.lockKey(key)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+		}
+
+		return m.handle(message, help)
+	})
+	if errors.Is(err, ErrWorkerStopped) {
+		return m.handOver(message, help.Job())
+	}
+	return err
+}
+
+// handOver gives the message of a stopped worker to the other consumers, it is not a failure:
+// a fresh copy starts over the attempts counted by nsqd, else the message is requeued without backoff
+func (m *messageHandler) handOver(message *nsq.Message, job *queue.Job) error {
+	if err := m.republish(job, 0); err != nil {
+		logx.Errorf("go-zero-utils: hand over job %s: %v", job.Jid, err)
+		message.RequeueWithoutBackoff(0)
+	}
+	return nil
+}
+
+func (m *messageHandler) handle(message *nsq.Message, help *helper) error {
+	job := help.Job()
+	m.client.inflight.Add(job)
+	defer m.client.inflight.Done(job)
+
+	if job.Expired() {
+		logx.Infof("go-zero-utils: discard expired job %s", job.Jid)
+		m.client.status.Failed(job, nil, true)
+		m.done(job, true)
+		return nil
+	}
+
+	if job.UniqueUntil() == queue.UntilStart {
+		m.client.unique.unlock(context.Background(), job)
+	}
+
+	// the processor runs in the consumer span, a child of the span which pushed the job
+	ctx, span := queue.StartJobSpan(m.client.Context(), job)
+	ctx, cancel := queue.JobContext(ctx, help, job.ReserveFor)
+	defer cancel()
+	go touch(ctx, message)
+
+	m.client.status.Running(job)
+	start := m.client.metrics.Started(job)
+	err := m.process(ctx, help, job)
+	m.client.metrics.Done(job, start, err)
+	queue.EndSpan(span, err)
+	if errors.Is(err, context.Canceled) && m.client.Context().Err() != nil {
+		// cut off by Stop, it runs again on another consumer untouched
+		m.client.status.Snoozed(job)
+		return m.handOver(message, job)
+	}
+	if err != nil {
+		return m.fail(job, err)
+	}
+	m.client.status.Succeeded(job)
+
+	if job.UniqueUntil() == queue.UntilSuccess {
+		m.client.unique.unlock(context.Background(), job)
+	}
+	m.done(job, false)
+
+	return nil
+}
+
+// lockKey waits for the local partition of key, the message is kept in flight by submit meanwhile,
+// the client stopping in the meantime stops the worker
+func (m *messageHandler) lockKey(key string) (func(), error) {
+	unlock, err := m.client.sequencer.Lock(m.client.Context(), key)
+	if err != nil {
+		return nil, ErrWorkerStopped
+	}
+	return unlock, nil
+}
+
+// process runs the processor, holding the redis lock of the ordering key of job if any
+func (m *messageHandler) process(ctx context.Context, help *helper, job *queue.Job) error {
+	if key := job.OrderingKey(); m.client.sequencer != nil && len(key) > 0 {
+		release, err := m.client.sequencer.Acquire(ctx, key, job.Jid)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	return m.processor(ctx, help, job.Args...)
+}
+
+// done reports a job finished for good to its batch and pushes its follow-ups,
+// jobs of the dlq were reported when they died
+func (m *messageHandler) done(job *queue.Job, dead bool) {
+	// a dead job is not enqueued anymore, so it can be pushed again, e.g. replayed from the dead letters
+	if dead {
+		m.client.unique.unlock(context.Background(), job)
+	}
+
+	if strings.HasSuffix(m.topic, TOPIC_DLQ_SUFFIX) {
+		return
+	}
+	m.client.jobDone(context.Background(), job, dead)
+
+	if dead {
+		m.client.workflow.Dead(context.Background(), job)
+	} else {
+		m.client.workflow.Succeeded(context.Background(), job)
+	}
+}
+
+// touch keeps the message in flight until the job context is done, so nsqd does not
+// redeliver jobs running longer than its --msg-timeout (default 60s)
+func touch(ctx context.Context, message *nsq.Message) {
+	ticker := time.NewTicker(touchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			message.Touch()
+		}
+	}
+}
+
+// fail records the failure in the job, then republishes it with backoff or sends it to the dlq
+// according to its retry policy. An error is only returned when the job can not be republished,
+// so nsq requeues the original message.
+func (m *messageHandler) fail(job *queue.Job, err error) error {
+	// over a limit, it runs again later untouched, this is not a failure
+	if delay, ok := queue.SnoozeDelay(err); ok {
+		m.client.status.Snoozed(job)
+		return m.republish(job, delay)
+	}
+
+	retry, delay := job.Fail(err, m.client.backoff)
+
+	// reprocessing failed, park the job, it keeps failing in the dlq slowly if it can not be parked
+	if strings.HasSuffix(m.topic, TOPIC_DLQ_SUFFIX) {
+		if parkErr := m.client.dlq.Park(job); parkErr == nil {
+			m.client.status.Failed(job, err, true)
+			return nil
+		}
+		m.client.status.Failed(job, err, false)
+		return m.republish(job, m.client.backoff(job.Failure.RetryCount))
+	}
 
-	logx.Infof("Working on job %s\n", help.Jid())
+	m.client.status.Failed(job, err, !retry)
+	if retry {
+		m.client.metrics.Retried(job)
+		return m.republish(job, delay)
+	}
+
+	// 0 = drop
+	if job.Discardable(err) {
+		logx.Infof("go-zero-utils: discard failed job %s", job.Jid)
+		m.client.metrics.Dead(job, queue.DeadActionDiscard)
+		m.done(job, true)
+		return nil
+	}
+
+	// -1 = straight to dlq, or retries exhausted
+	if err := m.client.dlq.RequeueDeadJob(job); err != nil {
+		return err
+	}
+	m.done(job, true)
+	return nil
+}
 
-	return m.processor(help, help.Job().Args...)
+// republish the job to its topic, nsq can not change the body of a requeued message
+func (m *messageHandler) republish(job *queue.Job, delay time.Duration) error {
+	return m.client.publish(context.Background(), m.topic, job, delay)
 }
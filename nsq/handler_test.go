@@ -0,0 +1,206 @@
+package nsq
+
+import (
+	"context"
+	"errors"
+	"github.com/toby1991/go-zero-utils/queue"
+	"testing"
+	"time"
+)
+
+func Test_messageHandler_fail(t *testing.T) {
+	retries := func(n int) *int { return &n }
+
+	tests := []struct {
+		name           string
+		retry          *int
+		failure        *queue.Failure // of the previous attempts
+		err            error
+		wantTopic      string // "" if dropped
+		wantRetryCount int
+		wantRemaining  int
+		wantDelay      bool
+	}{
+		{name: "first failure is retried", err: errors.New("boom"), wantTopic: "default", wantRetryCount: 0, wantRemaining: 24, wantDelay: true},
+		{name: "retry counted", retry: retries(3), failure: &queue.Failure{RetryCount: 0}, err: errors.New("boom"), wantTopic: "default", wantRetryCount: 1, wantRemaining: 1, wantDelay: true},
+		{name: "retries exhausted go to the dlq", retry: retries(1), failure: &queue.Failure{RetryCount: 0}, err: errors.New("boom"), wantTopic: "default" + TOPIC_DLQ_SUFFIX, wantRetryCount: 1},
+		{name: "dead error skips the retries", err: queue.Dead(errors.New("boom")), wantTopic: "default" + TOPIC_DLQ_SUFFIX},
+		{name: "ephemeral job dropped", retry: retries(queue.RetryPolicyEmphemeral), err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, nsqd, _ := newTestNsq(t, NsqConf{})
+
+			job := queue.NewJob("sync_goods", 1)
+			if tt.retry != nil {
+				job.Retry = tt.retry
+			}
+			job.Failure = tt.failure
+
+			err := handle(t, c, job, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+				return tt.err
+			})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v, the failure must be handled", err)
+			}
+
+			retried, dead := nsqd.jobs("default"), nsqd.jobs("default"+TOPIC_DLQ_SUFFIX)
+			published := append(retried, dead...)
+			if len(tt.wantTopic) <= 0 {
+				if len(published) > 0 {
+					t.Fatalf("published %d jobs, want none", len(published))
+				}
+				return
+			}
+			if len(published) != 1 || len(nsqd.jobs(tt.wantTopic)) != 1 {
+				t.Fatalf("published %d to the queue, %d to the dlq, want 1 to %s", len(retried), len(dead), tt.wantTopic)
+			}
+
+			failure := published[0].Failure
+			if failure == nil || failure.RetryCount != tt.wantRetryCount || failure.RetryRemaining != tt.wantRemaining || failure.ErrorMessage != tt.err.Error() {
+				t.Errorf("failure = %+v, want retry_count %d, remaining %d, message %q", failure, tt.wantRetryCount, tt.wantRemaining, tt.err)
+			}
+			if delay := nsqd.delays(tt.wantTopic)[0]; (delay >= 15*time.Second) != tt.wantDelay {
+				t.Errorf("delay = %s, want backoff %v", delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func Test_messageHandler_stopped(t *testing.T) {
+	c, nsqd, _ := newTestNsq(t, NsqConf{Worker: WorkerConf{Concurrency: 1}})
+
+	// the only worker is busy, the second job waits for it
+	running, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	busy := queue.NewJob("sync_goods", 1)
+	go handle(t, c, busy, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+		close(running)
+		<-release
+		return nil
+	})
+	<-running
+
+	waiting := queue.NewJob("sync_goods", 2)
+	message, delegate := newTestMessage(t, waiting)
+	message.Attempts = 5
+	handled := make(chan error, 1)
+	go func() {
+		handled <- newMessageHandler(c, waiting.Queue, waiting.Type, c.Then(waiting.Type, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+			t.Error("the waiting job must not run once the worker stopped")
+			return nil
+		})).HandleMessage(message)
+	}()
+	for {
+		c.prioritizer.mu.Lock()
+		queued := len(c.prioritizer.waiting["default"])
+		c.prioritizer.mu.Unlock()
+		if queued > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.prioritizer.Stop()
+	if err := <-handled; err != nil {
+		t.Fatalf("HandleMessage() error = %v, a stopped worker is not a failure", err)
+	}
+
+	if dead := nsqd.jobs("default" + TOPIC_DLQ_SUFFIX); len(dead) != 0 {
+		t.Errorf("dead-lettered %d jobs, want none", len(dead))
+	}
+	copies := nsqd.jobs("default")
+	if len(copies) != 1 || copies[0].Jid != waiting.Jid || copies[0].Failure != nil {
+		t.Errorf("published %+v, want a fresh copy of the waiting job", copies)
+	}
+	if len(delegate.requeued) != 0 {
+		t.Errorf("requeued %v, the copy replaces the message", delegate.requeued)
+	}
+}
+
+func Test_messageHandler_cancelled(t *testing.T) {
+	c, nsqd, _ := newTestNsq(t, NsqConf{})
+
+	// the job is cut off by Stop, it returns once cancelled
+	running := make(chan struct{})
+	job := queue.NewJob("sync_goods", 1)
+	handled := make(chan error, 1)
+	go func() {
+		handled <- handle(t, c, job, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+			close(running)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-running
+
+	c.cancel()
+	if err := <-handled; err != nil {
+		t.Fatalf("HandleMessage() error = %v, a cancelled job is not a failure", err)
+	}
+	if jobs := c.inflight.Jobs(); len(jobs) != 0 {
+		t.Errorf("in flight = %d jobs once handed over, want none", len(jobs))
+	}
+
+	copies := nsqd.jobs("default")
+	if len(copies) != 1 || copies[0].Jid != job.Jid || copies[0].Failure != nil {
+		t.Errorf("published %+v, want a fresh copy of the cancelled job", copies)
+	}
+}
+
+func Test_messageHandler_ordering(t *testing.T) {
+	c, _, _ := newTestNsq(t, NsqConf{Worker: WorkerConf{Concurrency: 1, Ordering: queue.OrderingConf{Enabled: true}}})
+
+	// the only worker is busy, the job of goods:1 waits for it
+	running, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	go handle(t, c, queue.NewJob("sync_goods", 1), func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+		close(running)
+		<-release
+		return nil
+	})
+	<-running
+
+	waiting := queue.NewJob("sync_goods", 2).SetOrderingKey("goods:1")
+	go handle(t, c, waiting, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+		return nil
+	})
+	for {
+		c.prioritizer.mu.Lock()
+		queued := len(c.prioritizer.waiting["default"])
+		c.prioritizer.mu.Unlock()
+		if queued > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// it does not hold the partition of its key while it waits
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	unlock, err := c.sequencer.Lock(ctx, "goods:1")
+	if err != nil {
+		t.Fatalf("Lock() error = %v, the partition is held by a job waiting for a worker", err)
+	}
+	unlock()
+}
+
+func Test_nsqClient_handles(t *testing.T) {
+	c, _, _ := newTestNsq(t, NsqConf{})
+	c.Register("sync_goods", func(helper queue.Helper, args ...interface{}) error { return nil })
+
+	tests := []struct {
+		topic   string
+		jobType string
+		want    bool
+	}{
+		{topic: "default", jobType: "sync_goods", want: true},
+		{topic: "default", jobType: "sync_orders", want: false},
+		{topic: "critical", jobType: "sync_goods", want: false},
+	}
+	for _, tt := range tests {
+		if got := c.handles(tt.topic, tt.jobType); got != tt.want {
+			t.Errorf("handles(%s, %s) = %v, want %v", tt.topic, tt.jobType, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package nsq
 
 import (
+	"context"
 	"encoding/json"
 	faktory "github.com/contribsys/faktory/client"
 	"github.com/nsqio/go-nsq"
@@ -8,11 +9,36 @@ import (
 	"time"
 )
 
+// Helper is the queue.Helper given to nsq processors, with the features nsq implements its own way
+//
+//	func(helper queue.Helper, args ...interface{}) error {
+//	    return helper.(nsq.Helper).WithClient(func(client nsq.NsqClient) error {
+//	        return client.Push(queue.NewJob("follow_up"))
+//	    })
+//	}
+type Helper interface {
+	queue.Helper
+
+	Job() *queue.Job
+
+	// OpenBatch opens the batch of the job, so more jobs can be added to it
+	OpenBatch(fn func(*Batch) error) error
+
+	// WithClient gives access to the client running the job, e.g. to push follow-up jobs
+	WithClient(fn func(NsqClient) error) error
+}
+
 type helper struct {
 	message *nsq.Message
 	job     *queue.Job
+	client  *nsqClient
 }
 
+// ensure type compatibility
+var _ Helper = &helper{}
+
+// HelperFor decodes the job of message, the helper is not bound to a client:
+// OpenBatch, WithClient and TrackProgress return queue.ErrUnsupported
 func HelperFor(message *nsq.Message) (*helper, error) {
 
 	var job queue.Job
@@ -28,8 +54,7 @@ func (h *helper) Job() *queue.Job {
 }
 
 func (h *helper) Jid() string {
-	//return string(hash.Md5(h.message.ID[:]))
-	return string(h.message.ID[:])
+	return h.Job().Jid
 }
 
 // Channel = job.Type
@@ -43,26 +68,65 @@ func (h *helper) Custom(key string) (value interface{}, ok bool) {
 }
 
 func (h *helper) Bid() string {
-	//TODO implement me
-	return string(h.message.ID[:])
+	if b, ok := h.Job().GetCustom("bid"); ok {
+		bid, _ := b.(string)
+		return bid
+	}
+	return ""
 }
 
 func (h *helper) CallbackBid() string {
-	//TODO implement me
-	panic("implement me")
+	if b, ok := h.Job().GetCustom("_bid"); ok {
+		bid, _ := b.(string)
+		return bid
+	}
+	return ""
 }
 
+// Batch is faktory only, use OpenBatch
 func (h *helper) Batch(f func(*faktory.Batch) error) error {
-	//TODO implement me
-	panic("implement me")
+	return queue.ErrUnsupported
 }
 
+// With is faktory only, use WithClient
 func (h *helper) With(f func(*faktory.Client) error) error {
-	//TODO implement me
-	panic("implement me")
+	return queue.ErrUnsupported
+}
+
+func (h *helper) OpenBatch(fn func(*Batch) error) error {
+	if h.client == nil {
+		return queue.ErrUnsupported
+	}
+	bid := h.Bid()
+	if len(bid) <= 0 {
+		return ErrBatchNotFound
+	}
+
+	b, err := h.client.OpenBatch(context.Background(), bid)
+	if err != nil {
+		return err
+	}
+
+	return b.Jobs(func() error {
+		return fn(b)
+	})
 }
 
+func (h *helper) WithClient(fn func(NsqClient) error) error {
+	if h.client == nil {
+		return queue.ErrUnsupported
+	}
+	return fn(h.client)
+}
+
+// TrackProgress records the progress in the status store, see nsqClient.SetStatusStore,
+// it also touches the message so nsqd does not redeliver it.
 func (h *helper) TrackProgress(percent int, desc string, reserveUntil *time.Time) error {
-	//TODO implement me
-	panic("implement me")
+	h.message.Touch()
+	if h.client == nil || h.client.status == nil {
+		return queue.ErrUnsupported
+	}
+
+	h.client.status.Progress(h.Job(), percent, desc)
+	return nil
 }
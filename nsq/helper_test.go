@@ -0,0 +1,33 @@
+package nsq
+
+import (
+	"errors"
+	"github.com/toby1991/go-zero-utils/queue"
+	"testing"
+)
+
+func TestHelperFor(t *testing.T) {
+	job := queue.NewJob("sync_goods", 1)
+	job.SetCustom("bid", "b-1")
+	message, _ := newTestMessage(t, job)
+
+	help, err := HelperFor(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if help.Jid() != job.Jid || help.JobType() != job.Type {
+		t.Errorf("helper of %s %s, want %s %s", help.Jid(), help.JobType(), job.Jid, job.Type)
+	}
+
+	// the helper is not bound to a client
+	tests := map[string]func() error{
+		"OpenBatch":     func() error { return help.OpenBatch(func(*Batch) error { return nil }) },
+		"WithClient":    func() error { return help.WithClient(func(NsqClient) error { return nil }) },
+		"TrackProgress": func() error { return help.TrackProgress(50, "half", nil) },
+	}
+	for name, call := range tests {
+		if err := call(); !errors.Is(err, queue.ErrUnsupported) {
+			t.Errorf("%s() error = %v, want ErrUnsupported", name, err)
+		}
+	}
+}
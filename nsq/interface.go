@@ -3,13 +3,17 @@ package nsq
 import (
 	"context"
 	"github.com/toby1991/go-zero-utils/queue"
-	"github.com/zeromicro/go-zero/core/service"
 )
 
 type NsqClient interface {
-	service.Service
+	queue.Client
 
-	Context() context.Context
+	// SetProcessor subscribes the processors by topic and channel, the channel must be the job type
 	SetProcessor(jobTopicChannelMapWithProcessor map[Topic]ChannelProcessorMap)
-	Push(job *queue.Job) error
+}
+
+// BatchClient groups jobs in batches, it requires Redis
+type BatchClient interface {
+	NewBatch() *Batch
+	OpenBatch(ctx context.Context, bid string) (*Batch, error)
 }
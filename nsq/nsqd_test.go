@@ -0,0 +1,268 @@
+package nsq
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nsqio/go-nsq"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/queue"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// published is a message received by fakeNsqd
+type published struct {
+	topic string
+	delay time.Duration
+	body  []byte
+}
+
+// fakeNsqd accepts the producers' PUB, DPUB and MPUB, so the tests run without nsqd
+type fakeNsqd struct {
+	listener net.Listener
+
+	mu        sync.Mutex
+	published []published
+	rejected  map[string]bool // topics whose publishes fail
+}
+
+func newFakeNsqd(t *testing.T) *fakeNsqd {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	d := &fakeNsqd{listener: listener, rejected: make(map[string]bool)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeNsqd) addr() string {
+	return d.listener.Addr().String()
+}
+
+// jobs returns the jobs published to topic
+func (d *fakeNsqd) jobs(topic string) []*queue.Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	jobs := make([]*queue.Job, 0)
+	for _, p := range d.published {
+		if p.topic != topic {
+			continue
+		}
+		var job queue.Job
+		if err := json.Unmarshal(p.body, &job); err == nil {
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs
+}
+
+// delays returns the delays of the messages published to topic
+func (d *fakeNsqd) delays(topic string) []time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delays := make([]time.Duration, 0)
+	for _, p := range d.published {
+		if p.topic == topic {
+			delays = append(delays, p.delay)
+		}
+	}
+	return delays
+}
+
+// reject makes the publishes to topic fail
+func (d *fakeNsqd) reject(topic string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rejected[topic] = true
+}
+
+func (d *fakeNsqd) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		params := strings.Fields(line)
+		if len(params) <= 0 {
+			continue
+		}
+
+		if len(params) > 1 && d.isRejected(params[1]) {
+			if _, err := readBody(r); err != nil {
+				return
+			}
+			frame(conn, nsq.FrameTypeError, "E_PUB_FAILED")
+			continue
+		}
+
+		switch params[0] {
+		case "NOP":
+			continue
+		case "CLS":
+			respond(conn, "CLOSE_WAIT")
+			return
+		case "IDENTIFY":
+			if _, err := readBody(r); err != nil {
+				return
+			}
+		case "PUB", "DPUB":
+			body, err := readBody(r)
+			if err != nil {
+				return
+			}
+			var delay time.Duration
+			if params[0] == "DPUB" {
+				ms, _ := strconv.Atoi(params[2])
+				delay = time.Duration(ms) * time.Millisecond
+			}
+			d.record(published{topic: params[1], delay: delay, body: body})
+		case "MPUB":
+			body, err := readBody(r)
+			if err != nil {
+				return
+			}
+			num := binary.BigEndian.Uint32(body)
+			body = body[4:]
+			for i := uint32(0); i < num; i++ {
+				size := binary.BigEndian.Uint32(body)
+				d.record(published{topic: params[1], body: body[4 : 4+size]})
+				body = body[4+size:]
+			}
+		}
+		respond(conn, "OK")
+	}
+}
+
+func (d *fakeNsqd) isRejected(topic string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rejected[topic]
+}
+
+func (d *fakeNsqd) record(p published) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.published = append(d.published, p)
+}
+
+func readBody(r io.Reader) ([]byte, error) {
+	var size int32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	body := make([]byte, size)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// respond writes a response frame
+func respond(w io.Writer, data string) {
+	frame(w, nsq.FrameTypeResponse, data)
+}
+
+func frame(w io.Writer, frameType int32, data string) {
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(4+len(data)))
+	binary.BigEndian.PutUint32(buf[4:], uint32(frameType))
+	copy(buf[8:], data)
+	w.Write(buf)
+}
+
+// newTestNsq returns a started client publishing to a fake nsqd, with an in-memory redis
+func newTestNsq(t *testing.T, conf NsqConf) (*nsqClient, *fakeNsqd, *miniredis.Miniredis) {
+	nsqd := newFakeNsqd(t)
+	redis := miniredis.RunT(t)
+
+	conf.Sender.NsqdAddrs = []string{nsqd.addr()}
+	port, _ := strconv.Atoi(redis.Port())
+	conf.Redis = bizredis.BizRedisConf{Host: redis.Host(), Port: port}
+	if conf.Worker.Concurrency <= 0 {
+		conf.Worker.Concurrency = 2
+	}
+	if conf.Worker.PullFromQueuesWithPriority == nil {
+		conf.Worker.PullFromQueuesWithPriority = map[string]int{"default": 1}
+	}
+	if conf.Worker.RetryBackoff <= 0 {
+		conf.Worker.RetryBackoff, conf.Worker.MaxRetryBackoff = 15*time.Second, time.Hour
+	}
+	if conf.Worker.ShutdownTimeout <= 0 {
+		conf.Worker.ShutdownTimeout = time.Second
+	}
+	if conf.Scheduler.MaxDeferredDelay <= 0 {
+		conf.Scheduler.MaxDeferredDelay = 50 * time.Minute
+	}
+
+	c := NewNsq(conf)
+	c.processing(context.Background(), nil)
+	t.Cleanup(func() {
+		c.prioritizer.Stop()
+		c.cancel()
+		c.senderPool.Stop()
+	})
+	return c, nsqd, redis
+}
+
+// testDelegate records how the handler responded to a message
+type testDelegate struct {
+	mu       sync.Mutex
+	requeued []time.Duration
+	backoff  []bool
+}
+
+func (d *testDelegate) OnFinish(*nsq.Message) {}
+func (d *testDelegate) OnTouch(*nsq.Message)  {}
+func (d *testDelegate) OnRequeue(m *nsq.Message, delay time.Duration, backoff bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requeued = append(d.requeued, delay)
+	d.backoff = append(d.backoff, backoff)
+}
+
+func newTestMessage(t *testing.T, job *queue.Job) (*nsq.Message, *testDelegate) {
+	body, err := job.JsonBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var id nsq.MessageID
+	copy(id[:], job.Jid)
+	message := nsq.NewMessage(id, body)
+	delegate := &testDelegate{}
+	message.Delegate = delegate
+	return message, delegate
+}
+
+// handle runs job through a handler of processor, as the consumer of its queue would
+func handle(t *testing.T, c *nsqClient, job *queue.Job, processor queue.ContextJobProcessor) error {
+	message, _ := newTestMessage(t, job)
+	return newMessageHandler(c, job.Queue, job.Type, c.Then(job.Type, processor)).HandleMessage(message)
+}
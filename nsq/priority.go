@@ -0,0 +1,155 @@
+package nsq
+
+import (
+	"errors"
+	"github.com/nsqio/go-nsq"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrWorkerStopped = errors.New("nsq: worker is stopped")
+
+// prioritizer shares a budget of workers between topics, like faktory's weighted queues:
+// every free worker picks a topic with waiting messages at random, in proportion to its weight,
+// so higher weights are drained preferentially while lower ones are not starved.
+//
+// nsq pushes messages to the consumers, their handlers wait here until a worker runs them.
+type prioritizer struct {
+	weights     map[string]int
+	concurrency int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	waiting map[string][]*task // topic => tasks in arrival order
+	stopped bool
+}
+
+type task struct {
+	run  func() error
+	done chan error
+}
+
+func newPrioritizer(weights map[string]int, concurrency int) *prioritizer {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	p := &prioritizer{
+		weights:     weights,
+		concurrency: concurrency,
+		waiting:     make(map[string][]*task),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// weight of topic, dlq topics and topics not configured weigh 1
+func (p *prioritizer) weight(topic string) int {
+	if weight, ok := p.weights[topic]; ok && weight > 0 && !strings.HasSuffix(topic, TOPIC_DLQ_SUFFIX) {
+		return weight
+	}
+	return 1
+}
+
+func (p *prioritizer) Start() {
+	for i := 0; i < p.concurrency; i++ {
+		go p.work()
+	}
+}
+
+// Stop rejects the waiting messages, their handler hands them over to the other consumers,
+// workers exit once their task is done
+func (p *prioritizer) Stop() {
+	p.mu.Lock()
+	p.stopped = true
+	for topic, tasks := range p.waiting {
+		for _, t := range tasks {
+			t.done <- ErrWorkerStopped
+		}
+		delete(p.waiting, topic)
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// submit waits for a worker to run fn, the message is touched meanwhile so nsqd does not redeliver it
+func (p *prioritizer) submit(topic string, message *nsq.Message, fn func() error) error {
+	t := &task{run: fn, done: make(chan error, 1)}
+
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return ErrWorkerStopped
+	}
+	p.waiting[topic] = append(p.waiting[topic], t)
+	p.cond.Signal()
+	p.mu.Unlock()
+
+	ticker := time.NewTicker(touchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-t.done:
+			return err
+		case <-ticker.C:
+			message.Touch()
+		}
+	}
+}
+
+func (p *prioritizer) work() {
+	for {
+		t, ok := p.next()
+		if !ok {
+			return
+		}
+
+		t.done <- t.run()
+	}
+}
+
+// next blocks until a task is picked, it returns false once stopped
+func (p *prioritizer) next() (*task, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.stopped {
+			return nil, false
+		}
+
+		if topic, ok := p.pick(); ok {
+			t := p.waiting[topic][0]
+			p.waiting[topic] = p.waiting[topic][1:]
+			if len(p.waiting[topic]) <= 0 {
+				delete(p.waiting, topic)
+			}
+			return t, true
+		}
+
+		p.cond.Wait()
+	}
+}
+
+// pick chooses a topic with waiting tasks at random, weighted, p.mu must be held
+func (p *prioritizer) pick() (string, bool) {
+	total := 0
+	for topic := range p.waiting {
+		total += p.weight(topic)
+	}
+	if total <= 0 {
+		return "", false
+	}
+
+	r := rand.Intn(total)
+	for topic := range p.waiting {
+		r -= p.weight(topic)
+		if r < 0 {
+			return topic, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,64 @@
+package nsq
+
+import "testing"
+
+func Test_prioritizer_next(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		waiting map[string]int // tasks waiting by topic
+		picks   int
+		want    map[string]int // expected picks by topic, within 10%
+	}{
+		{
+			name:    "weighted",
+			weights: map[string]int{"critical": 3, "default": 2, "bulk": 1},
+			waiting: map[string]int{"critical": 10000, "default": 10000, "bulk": 10000},
+			picks:   12000,
+			want:    map[string]int{"critical": 6000, "default": 4000, "bulk": 2000},
+		},
+		{
+			name:    "dlq weighs 1",
+			weights: map[string]int{"critical": 3},
+			waiting: map[string]int{"critical": 10000, "critical-dlq": 10000},
+			picks:   8000,
+			want:    map[string]int{"critical": 6000, "critical-dlq": 2000},
+		},
+		{
+			name:    "drained topics do not block",
+			weights: map[string]int{"critical": 3, "bulk": 1},
+			waiting: map[string]int{"critical": 10, "bulk": 1000},
+			picks:   510,
+			want:    map[string]int{"critical": 10, "bulk": 500},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPrioritizer(tt.weights, 1)
+			got := make(map[string]int)
+			for topic, n := range tt.waiting {
+				topic := topic
+				for i := 0; i < n; i++ {
+					p.waiting[topic] = append(p.waiting[topic], &task{run: func() error {
+						got[topic]++
+						return nil
+					}})
+				}
+			}
+
+			for i := 0; i < tt.picks; i++ {
+				task, ok := p.next()
+				if !ok {
+					t.Fatal("next() stopped")
+				}
+				task.run()
+			}
+
+			for topic, want := range tt.want {
+				if diff := got[topic] - want; diff > want/10 || -diff > want/10 {
+					t.Errorf("%s picked %d times, want about %d", topic, got[topic], want)
+				}
+			}
+		})
+	}
+}
@@ -1,57 +1,372 @@
 package nsq
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/nsqio/go-nsq"
+	"github.com/zeromicro/go-zero/core/logx"
+	"net"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
+var ErrNoNsqdAvailable = errors.New("nsq: no nsqd available")
+
+// pooledProducer is a producer of the pool with its health, an unhealthy producer is skipped
+// by Publish until a probe pings it successfully.
+type pooledProducer struct {
+	addr     string
+	producer *nsq.Producer
+	healthy  bool
+	static   bool // configured by SenderConf.NsqdAddrs, never removed by the discovery
+}
+
 type ProducerPool struct {
-	nsqdAddresses []string
-	conf          *nsq.Config
-	producers     []*nsq.Producer
-	sync.Mutex        // 互斥锁保护以下字段
-	index         int // 当前使用的producer的索引
+	_conf      SenderConf
+	conf       *nsq.Config
+	producers  []*pooledProducer
+	sync.Mutex     // 互斥锁保护以下字段
+	index      int // 当前使用的producer的索引
+
+	httpClient *http.Client
+	stop       chan struct{}
+	wg         sync.WaitGroup
 }
 
-func newProducerPool(nsqdAddresses []string, conf *nsq.Config) (*ProducerPool, error) {
-	var producers []*nsq.Producer
-	for _, addr := range nsqdAddresses {
-		producer, err := nsq.NewProducer(addr, conf)
-		if err != nil {
-			return nil, err
-		}
+// newProducerPool connects to the configured nsqd, unreachable ones are probed again later,
+// it fails only if none is reachable and no nsqlookupd is configured to discover others.
+func newProducerPool(senderConf SenderConf, conf *nsq.Config) (*ProducerPool, error) {
+	p := &ProducerPool{
+		_conf:      senderConf,
+		conf:       conf,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		stop:       make(chan struct{}),
+	}
 
-		err = producer.Ping()
-		if err != nil {
-			producer.Stop()
+	for _, addr := range senderConf.NsqdAddrs {
+		if err := p.add(addr, true); err != nil {
+			p.stopProducers()
 			return nil, err
 		}
+	}
+	if len(senderConf.NsqLookupdAddrs) > 0 {
+		p.discover()
+	}
+
+	if len(p.healthyProducers()) <= 0 && len(senderConf.NsqLookupdAddrs) <= 0 {
+		p.stopProducers()
+		return nil, ErrNoNsqdAvailable
+	}
+
+	p.wg.Add(1)
+	go p.maintain()
+
+	return p, nil
+}
+
+// AddNsqd adds an nsqd to the pool at runtime
+func (p *ProducerPool) AddNsqd(addr string) error {
+	return p.add(addr, true)
+}
+
+// RemoveNsqd removes an nsqd from the pool at runtime
+func (p *ProducerPool) RemoveNsqd(addr string) {
+	p.Lock()
+	defer p.Unlock()
 
-		producers = append(producers, producer)
+	for i, pp := range p.producers {
+		if pp.addr == addr {
+			pp.producer.Stop()
+			p.producers = append(p.producers[:i], p.producers[i+1:]...)
+			return
+		}
 	}
+}
 
-	return &ProducerPool{
-		nsqdAddresses: nsqdAddresses,
-		conf:          conf,
-		producers:     producers,
-	}, nil
+// Addrs returns the nsqd of the pool, and whether they are healthy
+func (p *ProducerPool) Addrs() map[string]bool {
+	p.Lock()
+	defer p.Unlock()
+
+	addrs := make(map[string]bool, len(p.producers))
+	for _, pp := range p.producers {
+		addrs[pp.addr] = pp.healthy
+	}
+	return addrs
 }
 
 func (p *ProducerPool) Publish(topic string, delay time.Duration, message []byte) error {
+	return p.do(func(producer *nsq.Producer) error {
+		if delay > 0 {
+			return producer.DeferredPublish(topic, delay, message)
+		}
+		return producer.Publish(topic, message)
+	})
+}
+
+// MultiPublish publishes messages to topic at once (MPUB), either all of them or none
+func (p *ProducerPool) MultiPublish(topic string, messages [][]byte) error {
+	return p.do(func(producer *nsq.Producer) error {
+		return producer.MultiPublish(topic, messages)
+	})
+}
+
+// DeferredMultiPublish publishes messages to topic after delay, nsqd has no deferred MPUB,
+// so the DPUBs are pipelined on one connection. A producer failing retries only the failed messages on the next one.
+// It returns the error of every message, nil if published.
+func (p *ProducerPool) DeferredMultiPublish(topic string, delay time.Duration, messages [][]byte) []error {
+	errs := make([]error, len(messages))
+	pending := make([]int, len(messages))
+	for i := range messages {
+		pending[i] = i
+	}
+
+	err := p.do(func(producer *nsq.Producer) error {
+		done := make(chan *nsq.ProducerTransaction, len(pending))
+		sent := 0
+		for _, i := range pending {
+			if errs[i] = producer.DeferredPublishAsync(topic, delay, messages[i], done, i); errs[i] == nil {
+				sent++
+			}
+		}
+		for ; sent > 0; sent-- {
+			t := <-done
+			errs[t.Args[0].(int)] = t.Error
+		}
+
+		failed := pending[:0]
+		for _, i := range pending {
+			if errs[i] != nil {
+				failed = append(failed, i)
+			}
+		}
+		pending = failed
+		if len(pending) > 0 {
+			return errs[pending[0]]
+		}
+		return nil
+	})
+	if err != nil {
+		for _, i := range pending {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// do runs fn on the healthy producers in turn until one succeeds, a failed producer is marked unhealthy,
+// the unhealthy ones are only tried when all the healthy ones failed.
+func (p *ProducerPool) do(fn func(producer *nsq.Producer) error) error {
+	healthy, unhealthy := p.candidates()
+
+	err := ErrNoNsqdAvailable
+	for _, pp := range append(healthy, unhealthy...) {
+		if err = fn(pp.producer); err == nil {
+			p.setHealthy(pp, true)
+			return nil
+		}
+
+		logx.Errorf("go-zero-utils: publish to nsqd %s: %v", pp.addr, err)
+		p.setHealthy(pp, false)
+	}
+	return err
+}
+
+func (p *ProducerPool) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+
+	p.stopProducers()
+}
+
+// candidates returns the producers in round-robin order, split by health
+func (p *ProducerPool) candidates() (healthy []*pooledProducer, unhealthy []*pooledProducer) {
 	p.Lock()
-	producer := p.producers[p.index]
-	p.index = (p.index + 1) % len(p.producers)
+	defer p.Unlock()
+
+	if len(p.producers) <= 0 {
+		return nil, nil
+	}
+
+	start := p.index % len(p.producers)
+	p.index = (start + 1) % len(p.producers)
+	for i := 0; i < len(p.producers); i++ {
+		pp := p.producers[(start+i)%len(p.producers)]
+		if pp.healthy {
+			healthy = append(healthy, pp)
+		} else {
+			unhealthy = append(unhealthy, pp)
+		}
+	}
+	return healthy, unhealthy
+}
+
+func (p *ProducerPool) healthyProducers() []*pooledProducer {
+	healthy, _ := p.candidates()
+	return healthy
+}
+
+func (p *ProducerPool) setHealthy(pp *pooledProducer, healthy bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if pp.healthy != healthy {
+		logx.Infof("go-zero-utils: nsqd %s healthy: %v", pp.addr, healthy)
+	}
+	pp.healthy = healthy
+}
+
+// add connects to addr if it is not in the pool yet, an unreachable nsqd is added as unhealthy
+func (p *ProducerPool) add(addr string, static bool) error {
+	p.Lock()
+	for _, pp := range p.producers {
+		if pp.addr == addr {
+			pp.static = pp.static || static
+			p.Unlock()
+			return nil
+		}
+	}
 	p.Unlock()
 
-	if delay > 0 {
-		return producer.DeferredPublish(topic, delay, message)
+	producer, err := nsq.NewProducer(addr, p.conf)
+	if err != nil {
+		return err
+	}
+
+	pp := &pooledProducer{addr: addr, producer: producer, static: static}
+	if err := producer.Ping(); err != nil {
+		logx.Errorf("go-zero-utils: nsqd %s unreachable: %v", addr, err)
+	} else {
+		pp.healthy = true
 	}
-	return producer.Publish(topic, message)
+
+	p.Lock()
+	p.producers = append(p.producers, pp)
+	p.Unlock()
+	return nil
 }
 
-func (p *ProducerPool) Stop() {
-	for _, producer := range p.producers {
-		producer.Stop()
+// maintain probes the unhealthy nsqd, and refreshes the nsqd discovered from nsqlookupd
+func (p *ProducerPool) maintain() {
+	defer p.wg.Done()
+
+	probeInterval := p._conf.ProbeInterval
+	if probeInterval <= 0 {
+		probeInterval = 5 * time.Second
+	}
+	probe := time.NewTicker(probeInterval)
+	defer probe.Stop()
+
+	discoveryInterval := p._conf.DiscoveryInterval
+	if discoveryInterval <= 0 {
+		discoveryInterval = 30 * time.Second
+	}
+	discovery := time.NewTicker(discoveryInterval)
+	defer discovery.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-probe.C:
+			p.probe()
+		case <-discovery.C:
+			if len(p._conf.NsqLookupdAddrs) > 0 {
+				p.discover()
+			}
+		}
+	}
+}
+
+func (p *ProducerPool) probe() {
+	_, unhealthy := p.candidates()
+	for _, pp := range unhealthy {
+		if err := pp.producer.Ping(); err == nil {
+			p.setHealthy(pp, true)
+		}
+	}
+}
+
+// discover adds the nsqd registered in nsqlookupd, and removes the discovered ones which are gone
+func (p *ProducerPool) discover() {
+	discovered := make(map[string]struct{})
+	succeeded := false
+	for _, lookupdAddr := range p._conf.NsqLookupdAddrs {
+		addrs, err := p.lookup(lookupdAddr)
+		if err != nil {
+			logx.Errorf("go-zero-utils: discover nsqd from %s: %v", lookupdAddr, err)
+			continue
+		}
+
+		succeeded = true
+		for _, addr := range addrs {
+			discovered[addr] = struct{}{}
+		}
+	}
+	if !succeeded {
+		return
+	}
+
+	for addr := range discovered {
+		if err := p.add(addr, false); err != nil {
+			logx.Errorf("go-zero-utils: add nsqd %s: %v", addr, err)
+		}
+	}
+
+	p.Lock()
+	gone := make([]string, 0)
+	for _, pp := range p.producers {
+		if _, ok := discovered[pp.addr]; !ok && !pp.static {
+			gone = append(gone, pp.addr)
+		}
+	}
+	p.Unlock()
+
+	for _, addr := range gone {
+		logx.Infof("go-zero-utils: nsqd %s is gone", addr)
+		p.RemoveNsqd(addr)
+	}
+}
+
+// lookup returns the tcp addresses of the nsqd registered in nsqlookupd, see its /nodes http endpoint
+func (p *ProducerPool) lookup(lookupdAddr string) ([]string, error) {
+	resp, err := p.httpClient.Get("http://" + lookupdAddr + "/nodes")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nsqlookupd responded %s", resp.Status)
+	}
+
+	var nodes struct {
+		Producers []struct {
+			BroadcastAddress string `json:"broadcast_address"`
+			TcpPort          int    `json:"tcp_port"`
+		} `json:"producers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&nodes); err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(nodes.Producers))
+	for _, node := range nodes.Producers {
+		addrs = append(addrs, net.JoinHostPort(node.BroadcastAddress, strconv.Itoa(node.TcpPort)))
+	}
+	return addrs, nil
+}
+
+func (p *ProducerPool) stopProducers() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, pp := range p.producers {
+		pp.producer.Stop()
 	}
 }
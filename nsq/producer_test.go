@@ -0,0 +1,109 @@
+package nsq
+
+import (
+	"errors"
+	"github.com/nsqio/go-nsq"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProducerPool_do(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthy     map[string]bool // addr => healthy, in pool order
+		failing     map[string]bool
+		wantTried   []string
+		wantErr     bool
+		wantHealthy map[string]bool
+	}{
+		{
+			name:        "first healthy succeeds",
+			healthy:     map[string]bool{"a:4150": true, "b:4150": true},
+			wantTried:   []string{"a:4150"},
+			wantHealthy: map[string]bool{"a:4150": true, "b:4150": true},
+		},
+		{
+			name:        "failover to the next healthy",
+			healthy:     map[string]bool{"a:4150": true, "b:4150": true},
+			failing:     map[string]bool{"a:4150": true},
+			wantTried:   []string{"a:4150", "b:4150"},
+			wantHealthy: map[string]bool{"a:4150": false, "b:4150": true},
+		},
+		{
+			name:        "unhealthy skipped",
+			healthy:     map[string]bool{"a:4150": false, "b:4150": true},
+			wantTried:   []string{"b:4150"},
+			wantHealthy: map[string]bool{"a:4150": false, "b:4150": true},
+		},
+		{
+			name:        "unhealthy tried last",
+			healthy:     map[string]bool{"a:4150": false, "b:4150": true},
+			failing:     map[string]bool{"b:4150": true},
+			wantTried:   []string{"b:4150", "a:4150"},
+			wantHealthy: map[string]bool{"a:4150": true, "b:4150": false},
+		},
+		{
+			name:        "all failing",
+			healthy:     map[string]bool{"a:4150": true},
+			failing:     map[string]bool{"a:4150": true},
+			wantTried:   []string{"a:4150"},
+			wantErr:     true,
+			wantHealthy: map[string]bool{"a:4150": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ProducerPool{}
+			addrs := make(map[*nsq.Producer]string)
+			for _, addr := range []string{"a:4150", "b:4150"} {
+				healthy, ok := tt.healthy[addr]
+				if !ok {
+					continue
+				}
+				producer, _ := nsq.NewProducer(addr, nsq.NewConfig())
+				addrs[producer] = addr
+				p.producers = append(p.producers, &pooledProducer{addr: addr, producer: producer, healthy: healthy})
+			}
+
+			var tried []string
+			err := p.do(func(producer *nsq.Producer) error {
+				tried = append(tried, addrs[producer])
+				if tt.failing[addrs[producer]] {
+					return errors.New("test error")
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tried, tt.wantTried) {
+				t.Errorf("tried %v, want %v", tried, tt.wantTried)
+			}
+			if got := p.Addrs(); !reflect.DeepEqual(got, tt.wantHealthy) {
+				t.Errorf("Addrs() = %v, want %v", got, tt.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestProducerPool_lookup(t *testing.T) {
+	lookupd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nodes" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"producers":[{"broadcast_address":"10.0.0.1","tcp_port":4150},{"broadcast_address":"10.0.0.2","tcp_port":4150}]}`))
+	}))
+	defer lookupd.Close()
+
+	p := &ProducerPool{httpClient: lookupd.Client()}
+	addrs, err := p.lookup(lookupd.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("lookup() error = %v", err)
+	}
+	if want := []string{"10.0.0.1:4150", "10.0.0.2:4150"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("lookup() = %v, want %v", addrs, want)
+	}
+}
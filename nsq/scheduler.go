@@ -0,0 +1,158 @@
+package nsq
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/cacher"
+	"github.com/zeromicro/go-zero/core/logx"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	scheduledKey = "nsq:scheduled" // sorted set of jobs waiting to be published, scored by due time
+	claimedKey   = "nsq:claimed"   // sorted set of jobs being published, scored by lease expiry
+)
+
+// claimScript moves due jobs from the scheduled set to the claimed set, and gives back
+// jobs whose lease has expired, e.g. the poller crashed before publishing them.
+var claimScript = bizredis.NewScript(`local expired = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, member in ipairs(expired) do
+    redis.call("ZREM", KEYS[2], member)
+    redis.call("ZADD", KEYS[1], ARGV[1], member)
+end
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, member in ipairs(due) do
+    redis.call("ZREM", KEYS[1], member)
+    redis.call("ZADD", KEYS[2], ARGV[3], member)
+end
+return due`)
+
+type scheduledJob struct {
+	Topic string          `json:"topic"`
+	Body  json.RawMessage `json:"body"`
+}
+
+// scheduler stores jobs due beyond nsqd's deferred publish limit in redis,
+// and publishes them once they are due. Jobs are published at least once:
+// a job claimed by a poller that dies before publishing is claimed again after Lease.
+type scheduler struct {
+	conf         SchedulerConf
+	redis        bizredis.RedisClient
+	producerPool *ProducerPool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newScheduler(conf SchedulerConf, redis bizredis.RedisClient, producerPool *ProducerPool) *scheduler {
+	return &scheduler{
+		conf:         conf,
+		redis:        redis,
+		producerPool: producerPool,
+		stop:         make(chan struct{}),
+	}
+}
+
+func (s *scheduler) key(raw string) string {
+	return cacher.NewKey(raw, s.redis.Prefix()).Prefixed()
+}
+
+// Schedule stores message to be published to topic at the given time
+func (s *scheduler) Schedule(ctx context.Context, topic string, at time.Time, message []byte) error {
+	member, err := json.Marshal(&scheduledJob{Topic: topic, Body: message})
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Client().ZAdd(ctx, s.key(scheduledKey), &redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: string(member),
+	}).Err()
+}
+
+func (s *scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		precision := s.conf.Precision
+		if precision <= 0 {
+			precision = time.Second
+		}
+		ticker := time.NewTicker(precision)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.poll(context.Background())
+			}
+		}
+	}()
+}
+
+func (s *scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// poll publishes every due job
+func (s *scheduler) poll(ctx context.Context) {
+	batchSize := s.conf.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	lease := s.conf.Lease
+	if lease <= 0 {
+		lease = time.Minute
+	}
+
+	for {
+		now := time.Now()
+		resp, err := s.redis.ScriptRunCtx(ctx, claimScript, []string{scheduledKey, claimedKey},
+			strconv.FormatInt(now.UnixMilli(), 10),
+			strconv.Itoa(batchSize),
+			strconv.FormatInt(now.Add(lease).UnixMilli(), 10),
+		)
+		if err != nil {
+			logx.Errorf("go-zero-utils: claim scheduled jobs: %v", err)
+			return
+		}
+
+		members, _ := resp.([]interface{})
+		for _, m := range members {
+			member, _ := m.(string)
+			s.publish(ctx, member)
+		}
+
+		if len(members) < batchSize {
+			return
+		}
+	}
+}
+
+func (s *scheduler) publish(ctx context.Context, member string) {
+	var job scheduledJob
+	if err := json.Unmarshal([]byte(member), &job); err != nil {
+		logx.Errorf("go-zero-utils: drop invalid scheduled job %s: %v", member, err)
+		s.redis.Client().ZRem(ctx, s.key(claimedKey), member)
+		return
+	}
+
+	// keep it claimed, it will be claimed again once the lease expires
+	if err := s.producerPool.Publish(job.Topic, 0, job.Body); err != nil {
+		logx.Errorf("go-zero-utils: publish scheduled job to %s: %v", job.Topic, err)
+		return
+	}
+
+	if err := s.redis.Client().ZRem(ctx, s.key(claimedKey), member).Err(); err != nil {
+		logx.Errorf("go-zero-utils: release scheduled job: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package nsq
+
+import (
+	"context"
+	"github.com/toby1991/go-zero-utils/queue"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_scheduler_poll(t *testing.T) {
+	c, nsqd, _ := newTestNsq(t, NsqConf{Scheduler: SchedulerConf{MaxDeferredDelay: time.Minute, Lease: time.Minute}})
+	ctx := context.Background()
+
+	// beyond MaxDeferredDelay, pushed to redis instead of nsqd
+	later := queue.NewJob("sync_goods", 1)
+	later.At = time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	if err := c.Push(later); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := nsqd.jobs("default"); len(jobs) != 0 {
+		t.Fatalf("published %d jobs, want the job scheduled in redis", len(jobs))
+	}
+
+	due := queue.NewJob("sync_goods", 2)
+	dueBody, _ := due.JsonBytes()
+	if err := c.scheduler.Schedule(ctx, "default", time.Now().Add(-time.Second), dueBody); err != nil {
+		t.Fatal(err)
+	}
+
+	// claimed by a poller which died before publishing, its lease expired
+	lost := queue.NewJob("sync_goods", 3)
+	lostBody, _ := lost.JsonBytes()
+	if err := c.scheduler.Schedule(ctx, "default", time.Now().Add(-time.Second), lostBody); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.redis.ScriptRunCtx(ctx, claimScript, []string{scheduledKey, claimedKey},
+		strconv.FormatInt(time.Now().UnixMilli(), 10), "1", strconv.FormatInt(time.Now().Add(-time.Millisecond).UnixMilli(), 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	c.scheduler.poll(ctx)
+
+	published := make(map[string]bool)
+	for _, job := range nsqd.jobs("default") {
+		published[job.Jid] = true
+	}
+	if len(published) != 2 || !published[due.Jid] || !published[lost.Jid] {
+		t.Errorf("published %v, want the due and the lost jobs", published)
+	}
+
+	scheduled, _ := c.redis.Client().ZCard(ctx, c.scheduler.key(scheduledKey)).Result()
+	claimed, _ := c.redis.Client().ZCard(ctx, c.scheduler.key(claimedKey)).Result()
+	if scheduled != 1 || claimed != 0 {
+		t.Errorf("scheduled = %d, claimed = %d, want 1, 0", scheduled, claimed)
+	}
+}
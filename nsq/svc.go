@@ -3,106 +3,275 @@ package nsq
 import (
 	"context"
 	"github.com/nsqio/go-nsq"
+	"github.com/toby1991/go-zero-utils/bizredis"
 	"github.com/toby1991/go-zero-utils/queue"
 	"time"
 )
 
+// cancelTimeout is how long the jobs cancelled by Stop have to hand their messages over
+const cancelTimeout = 5 * time.Second
+
 type Topic = string
 type Channel = string
 type ChannelProcessorMap = map[Channel]queue.JobProcessor
+
+// ensure type compatibility
+var (
+	_ NsqClient           = &nsqClient{}
+	_ queue.BulkPusher    = &nsqClient{}
+	_ queue.DeadLetterer  = &nsqClient{}
+	_ queue.GroupPusher   = &nsqClient{}
+	_ queue.StatusTracker = &nsqClient{}
+	_ BatchClient         = &nsqClient{}
+)
+
 type nsqClient struct {
-	_conf      NsqConf
-	senderPool *ProducerPool
-	workerPool *ConsumerPool
+	queue.Middlewares
+
+	_conf       NsqConf
+	senderPool  *ProducerPool
+	workerPool  *ConsumerPool
+	dlq         *dlq
+	backoff     queue.Backoff
+	redis       bizredis.RedisClient // nil if not configured
+	unique      *uniqueness
+	scheduler   *scheduler
+	sequencer   *queue.Sequencer // nil unless Worker.Ordering is enabled
+	prioritizer *prioritizer
+	inflight    queue.Inflight
+	status      *queue.StatusStore
+	metrics     *queue.Metrics
+	workflow    *queue.Workflow
 
-	jobTopicChannelMapWithProcessor map[Topic]ChannelProcessorMap // map[string]queue.JobProcessor
+	jobTopicChannelMapWithProcessor map[Topic]map[Channel]queue.ContextJobProcessor
 	ctx                             context.Context
 	cancel                          context.CancelFunc
 }
 
 func NewNsq(conf NsqConf) *nsqClient {
 	_nsqClient := &nsqClient{
-		_conf: conf,
+		_conf:   conf,
+		backoff: queue.ExponentialBackoff(conf.Worker.RetryBackoff, conf.Worker.MaxRetryBackoff),
+		metrics: queue.NewMetrics("nsq"),
 	}
 
+	_nsqClient.workflow = queue.NewWorkflow(_nsqClient)
+
+	// "Working on job" log, other middlewares may be registered by Use/UseFor
+	_nsqClient.Use(queue.Logging())
+
 	// config
 	_conf := nsq.NewConfig()
 	_conf.MaxInFlight = conf.Worker.MaxInFlight
 
+	// redis
+	if len(conf.Redis.Host) > 0 {
+		_nsqClient.redis = bizredis.NewRedis(conf.Redis)
+		_nsqClient.unique = newUniqueness(_nsqClient.redis)
+	}
+
 	// producer
 	var err error
-	if _nsqClient.senderPool, err = newProducerPool(conf.Sender.NsqdAddrs, _conf); err != nil {
+	if _nsqClient.senderPool, err = newProducerPool(conf.Sender, _conf); err != nil {
 		panic(err)
 	}
 
+	if _nsqClient.redis != nil {
+		_nsqClient.scheduler = newScheduler(conf.Scheduler, _nsqClient.redis, _nsqClient.senderPool)
+	}
+
+	// ordering, the redis lock only applies when Redis is configured
+	if conf.Worker.Ordering.Enabled {
+		_nsqClient.sequencer = queue.NewSequencer(conf.Worker.Ordering, _nsqClient.redis)
+	}
+
+	// dlq
+	var deadLetterStore queue.DeadLetterStore
+	if _nsqClient.redis != nil {
+		deadLetterStore = queue.NewRedisDeadLetterStore(_nsqClient.redis)
+	}
+	_nsqClient.dlq = newDlq(_nsqClient.senderPool, conf.Dlq, deadLetterStore, _nsqClient.metrics)
+
 	// consumer
 	_nsqClient.workerPool = newConsumerPool(conf.Worker.NsqLookupdAddrs, _conf)
+	_nsqClient.prioritizer = newPrioritizer(conf.Worker.PullFromQueuesWithPriority, conf.Worker.Concurrency)
 
 	return _nsqClient
 }
 
+func (c *nsqClient) SetDeadLetterStore(store queue.DeadLetterStore) {
+	c.dlq.store = store
+}
+func (c *nsqClient) DeadLetters() *queue.DeadLetters {
+	return queue.NewDeadLetters(c.dlq.store, c)
+}
+
+func (c *nsqClient) SetGroupStore(store queue.GroupStore) {
+	c.workflow.SetGroupStore(store)
+}
+func (c *nsqClient) PushGroup(ctx context.Context, callback *queue.Job, members ...*queue.Job) (string, error) {
+	return c.workflow.PushGroup(ctx, callback, members...)
+}
+
+func (c *nsqClient) SetStatusStore(store *queue.StatusStore) {
+	c.status = store
+}
+
+// SetProcessor subscribes the processors by topic and channel. The channel must be the job type:
+// every channel receives a copy of the messages of its topic and only runs the jobs of its own type.
 func (c *nsqClient) SetProcessor(jobTopicChannelMapWithProcessor map[Topic]ChannelProcessorMap) {
-	c.jobTopicChannelMapWithProcessor = jobTopicChannelMapWithProcessor
+	c.jobTopicChannelMapWithProcessor = make(map[Topic]map[Channel]queue.ContextJobProcessor)
+	for topic, channelMapWithProcessor := range jobTopicChannelMapWithProcessor {
+		for channel, processor := range channelMapWithProcessor {
+			c.registerProcessor(topic, channel, processor.WithContext())
+		}
+	}
+}
+
+// Register subscribes processor to every topic listed in Worker.PullFromQueuesWithPriority,
+// with jobType as the channel, just like a faktory worker pulls jobs from its queues.
+func (c *nsqClient) Register(jobType string, processor queue.JobProcessor) {
+	c.RegisterContext(jobType, processor.WithContext())
+}
+func (c *nsqClient) RegisterContext(jobType string, processor queue.ContextJobProcessor) {
+	for topic := range c._conf.Worker.PullFromQueuesWithPriority {
+		c.registerProcessor(topic, jobType, processor)
+	}
+}
+func (c *nsqClient) registerProcessor(topic Topic, channel Channel, processor queue.ContextJobProcessor) {
+	if c.jobTopicChannelMapWithProcessor == nil {
+		c.jobTopicChannelMapWithProcessor = make(map[Topic]map[Channel]queue.ContextJobProcessor)
+	}
+	if _, ok := c.jobTopicChannelMapWithProcessor[topic]; !ok {
+		c.jobTopicChannelMapWithProcessor[topic] = make(map[Channel]queue.ContextJobProcessor)
+	}
+	c.jobTopicChannelMapWithProcessor[topic][channel] = processor
+}
+
+// handles reports whether a channel of topic runs the jobs of jobType
+func (c *nsqClient) handles(topic Topic, jobType string) bool {
+	_, ok := c.jobTopicChannelMapWithProcessor[topic][jobType]
+	return ok
 }
 func (c *nsqClient) Context() context.Context {
 	return c.ctx
 }
 func (c *nsqClient) Start() {
 	c.processing(context.Background(), c.jobTopicChannelMapWithProcessor)
+
+	if c.scheduler != nil {
+		c.scheduler.Start()
+	}
 }
+
+// Stop shuts down in order: stop pulling new messages, wait for in-flight jobs up to
+// Worker.ShutdownTimeout, cancel the jobs still running and wait for them to hand their messages over,
+// flush dlq publishes, then close producers.
 func (c *nsqClient) Stop() {
-	c.senderPool.Stop()
 	c.workerPool.Stop()
-	c.cancel()
+	c.prioritizer.Stop()
+	if c.scheduler != nil {
+		c.scheduler.Stop()
+	}
+
+	abandoned := c.inflight.Wait(c._conf.Worker.ShutdownTimeout)
+	if c.cancel != nil {
+		c.cancel()
+		if len(abandoned) > 0 {
+			abandoned = c.inflight.Wait(cancelTimeout)
+		}
+	}
+	queue.ReportAbandoned(abandoned)
+
+	c.dlq.Flush(c._conf.Worker.ShutdownTimeout)
+	c.senderPool.Stop()
 }
 func (c *nsqClient) Push(job *queue.Job) error {
+	return c.PushCtx(context.Background(), job)
+}
+func (c *nsqClient) PushCtx(ctx context.Context, job *queue.Job) (err error) {
+	ctx, span := queue.StartPushSpan(ctx, job)
+	defer func() {
+		c.metrics.Push(job.Queue, err)
+		queue.EndSpan(span, err)
+	}()
+
 	// Topic = job.Queue
 	// Channel = job.Type
-	// delay = job.At // 可能不准，会比设定时间多一点
-
-	delay := time.Duration(0)
-	if len(job.At) > 0 {
-		//	job.At is time.RFC3339Nano string
-		jobAt, err := time.Parse(time.RFC3339Nano, job.At)
-		if err != nil {
-			return err
-		}
-		delay = jobAt.Sub(time.Now())
+	// delay = job.At // 可能不准，会比设定时间多一点，超过 Scheduler.MaxDeferredDelay 的由 redis 调度
+
+	delay, err := jobDelay(job)
+	if err != nil {
+		return err
+	}
+
+	// rejects duplicates within the unique_for window
+	if err := c.unique.lock(ctx, job); err != nil {
+		return err
+	}
+
+	pushedAt := time.Now()
+	if err := c.publish(ctx, job.Queue, job, delay); err != nil {
+		c.unique.unlock(ctx, job)
+		return err
+	}
+	c.status.Enqueued(job, pushedAt)
+
+	return nil
+}
+
+// jobDelay returns how long until job.At, 0 if not set
+func jobDelay(job *queue.Job) (time.Duration, error) {
+	if len(job.At) <= 0 {
+		return 0, nil
 	}
 
+	//	job.At is time.RFC3339Nano string
+	jobAt, err := time.Parse(time.RFC3339Nano, job.At)
+	if err != nil {
+		return 0, err
+	}
+	return jobAt.Sub(time.Now()), nil
+}
+
+// publish sends job to topic after delay, delays over nsqd's limit go through the redis scheduler
+func (c *nsqClient) publish(ctx context.Context, topic string, job *queue.Job, delay time.Duration) error {
 	jobJsonBytes, err := job.JsonBytes()
 	if err != nil {
 		return err
 	}
-	return c.senderPool.Publish(job.Queue, delay, jobJsonBytes)
+
+	if c.scheduler != nil && delay > c._conf.Scheduler.MaxDeferredDelay {
+		return c.scheduler.Schedule(ctx, topic, time.Now().Add(delay), jobJsonBytes)
+	}
+
+	return c.senderPool.Publish(topic, delay, jobJsonBytes)
 }
 
-func (c *nsqClient) processing(ctx context.Context, jobTopicChannelMapWithProcessor map[Topic]ChannelProcessorMap) {
+func (c *nsqClient) processing(ctx context.Context, jobTopicChannelMapWithProcessor map[Topic]map[Channel]queue.ContextJobProcessor) {
 	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.prioritizer.Start()
 
-	// dlq
-	_dlq := newDlq(c.senderPool)
+	// every consumer may fill the whole worker budget, the prioritizer decides which topic runs
+	concurrency := c._conf.Worker.Concurrency
 
 	// register processor
 	for topic, channelMapWithProcessor := range jobTopicChannelMapWithProcessor {
 		for channel, processor := range channelMapWithProcessor {
 
 			// register job processor one by one
-			newProcessor := processor
+			newProcessor := c.Then(channel, processor)
 
 			// Topic = job.Queue
 			// Channel = job.Type
 			// delay = job.At // 可能不准，会比设定时间多一点
-			concurrency, ok := c._conf.Worker.PullFromQueuesWithPriority[channel]
-			if !ok {
-				concurrency = 1
-			}
-			if err := c.workerPool.RegisterProcessor(topic, channel, newProcessor, concurrency, _dlq); err != nil {
+			if err := c.workerPool.RegisterHandler(topic, channel, newMessageHandler(c, topic, channel, newProcessor), concurrency); err != nil {
 				panic(err)
 			}
 
 			// topic 同时注册到 global-dlq, concurrency 为 1
-			if err := c.workerPool.RegisterProcessor(topic+TOPIC_DLQ_SUFFIX, channel, newProcessor, 1, _dlq); err != nil {
+			dlqTopic := topic + TOPIC_DLQ_SUFFIX
+			if err := c.workerPool.RegisterHandler(dlqTopic, channel, newMessageHandler(c, dlqTopic, channel, newProcessor), 1); err != nil {
 				panic(err)
 			}
 		}
@@ -0,0 +1,77 @@
+package nsq
+
+import (
+	"context"
+	"errors"
+	red "github.com/go-redis/redis/v8"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/queue"
+	"github.com/zeromicro/go-zero/core/logx"
+	"strconv"
+)
+
+const uniqueKeyPrefix = "nsq:unique:"
+
+var (
+	ErrRedisRequired = errors.New("nsq: Redis must be configured to use this feature")
+
+	uniqueLockScript   = bizredis.NewScript(`return redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2])`)
+	uniqueUnlockScript = bizredis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+else
+    return 0
+end`)
+)
+
+// uniqueness implements Faktory Enterprise's unique jobs on top of redis,
+// a unique job holds a lock named after its queue, type and args for unique_for seconds.
+type uniqueness struct {
+	store bizredis.RedisScripter
+}
+
+func newUniqueness(store bizredis.RedisScripter) *uniqueness {
+	return &uniqueness{store: store}
+}
+
+// lock is called by Push, it returns queue.ErrNotUnique if the same job is already locked
+func (u *uniqueness) lock(ctx context.Context, job *queue.Job) error {
+	uniqueFor := job.UniqueFor()
+	if uniqueFor <= 0 {
+		return nil
+	}
+	if u == nil {
+		return ErrRedisRequired
+	}
+
+	key, err := job.UniqueKey()
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.store.ScriptRunCtx(ctx, uniqueLockScript, []string{uniqueKeyPrefix + key}, job.Jid, strconv.FormatInt(uniqueFor.Milliseconds(), 10))
+	if err == red.Nil || resp == nil {
+		return queue.ErrNotUnique
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unlock releases the lock if it is still held by job, it is called when the
+// worker starts or succeeds according to the job's unique_until, and when the job dies
+func (u *uniqueness) unlock(ctx context.Context, job *queue.Job) {
+	if u == nil || job.UniqueFor() <= 0 {
+		return
+	}
+
+	key, err := job.UniqueKey()
+	if err != nil {
+		logx.Errorf("go-zero-utils: unique key of job %s: %v", job.Jid, err)
+		return
+	}
+
+	if _, err := u.store.ScriptRunCtx(ctx, uniqueUnlockScript, []string{uniqueKeyPrefix + key}, job.Jid); err != nil {
+		logx.Errorf("go-zero-utils: unlock unique job %s: %v", job.Jid, err)
+	}
+}
@@ -0,0 +1,88 @@
+package nsq
+
+import (
+	"context"
+	"errors"
+	"github.com/toby1991/go-zero-utils/queue"
+	"testing"
+	"time"
+)
+
+func Test_uniqueness(t *testing.T) {
+	tests := []struct {
+		name       string
+		until      queue.UniqueUntil
+		err        error // of the processor
+		wantPushed bool  // whether the same job can be pushed again once processed
+	}{
+		{name: "unlocked on success", err: nil, wantPushed: true},
+		{name: "locked while retried", err: errors.New("boom"), wantPushed: false},
+		{name: "unlocked on start", until: queue.UntilStart, err: errors.New("boom"), wantPushed: true},
+		{name: "unlocked once dead", err: queue.Dead(errors.New("boom")), wantPushed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, _ := newTestNsq(t, NsqConf{})
+
+			newJob := func() *queue.Job {
+				job := queue.NewJob("sync_goods", 1).SetUniqueFor(60)
+				if len(tt.until) > 0 {
+					job.SetUniqueness(tt.until)
+				}
+				return job
+			}
+
+			job := newJob()
+			if err := c.Push(job); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Push(newJob()); err != queue.ErrNotUnique {
+				t.Fatalf("Push() of a duplicate error = %v, want ErrNotUnique", err)
+			}
+
+			handle(t, c, job, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+				return tt.err
+			})
+
+			if err := c.Push(newJob()); (err == nil) != tt.wantPushed {
+				t.Errorf("Push() once processed error = %v, want pushed %v", err, tt.wantPushed)
+			}
+		})
+	}
+}
+
+func Test_nsqClient_replayUniqueJob(t *testing.T) {
+	c, nsqd, _ := newTestNsq(t, NsqConf{Dlq: queue.DlqConf{Mode: queue.DlqPark}})
+
+	job := queue.NewJob("sync_goods", 1).SetUniqueFor(60)
+	if err := c.Push(job); err != nil {
+		t.Fatal(err)
+	}
+	handle(t, c, nsqd.jobs("default")[0], func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+		return queue.Dead(errors.New("boom"))
+	})
+
+	if err := c.DeadLetters().Replay(context.Background(), job.Jid); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if jobs := nsqd.jobs("default"); len(jobs) != 2 || jobs[1].Jid != job.Jid || jobs[1].Failure != nil {
+		t.Errorf("published %d jobs, want the replayed one", len(jobs))
+	}
+}
+
+func Test_messageHandler_expired(t *testing.T) {
+	c, nsqd, _ := newTestNsq(t, NsqConf{})
+
+	job := queue.NewJob("sync_goods", 1).SetExpiresAt(time.Now().Add(-time.Minute))
+	ran := false
+	err := handle(t, c, job, func(ctx context.Context, helper queue.Helper, args ...interface{}) error {
+		ran = true
+		return nil
+	})
+	if err != nil || ran {
+		t.Errorf("HandleMessage() error = %v, ran = %v, want the expired job dropped", err, ran)
+	}
+	if jobs := nsqd.jobs("default" + TOPIC_DLQ_SUFFIX); len(jobs) > 0 {
+		t.Errorf("expired job sent to the dlq")
+	}
+}
@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+	"github.com/zeromicro/go-zero/core/service"
+)
+
+// Client is implemented by every queue backend (nsq, faktory, ...), so
+// producers and workers can switch brokers without being rewritten.
+//
+// The optional capabilities are small interfaces, type-assert the client for them:
+//
+//	if bulk, ok := client.(queue.BulkPusher); ok {
+//	    failed, err := bulk.PushBulk(jobs)
+//	}
+type Client interface {
+	service.Service
+
+	Context() context.Context
+
+	// Register binds processor to jobType, it must be called before Start.
+	Register(jobType string, processor JobProcessor)
+	// RegisterContext is the same as Register, for processors observing the job context.
+	RegisterContext(jobType string, processor ContextJobProcessor)
+	Push(job *Job) error
+	// PushCtx is the same as Push, the trace of ctx is propagated to the job, see StartPushSpan.
+	PushCtx(ctx context.Context, job *Job) error
+
+	// Use registers middlewares for every job type, UseFirst ahead of the registered ones, UseFor for jobType only.
+	Use(middlewares ...Middleware)
+	UseFirst(middlewares ...Middleware)
+	UseFor(jobType string, middlewares ...Middleware)
+}
+
+// BulkPusher pushes jobs in as few round trips as the broker allows
+type BulkPusher interface {
+	// PushBulk returns the error of every job which was not pushed by jid, err is set if none could be pushed.
+	PushBulk(jobs []*Job) (failed map[string]error, err error)
+}
+
+// DeadLetterer parks the dead jobs, see DlqConf
+type DeadLetterer interface {
+	// SetDeadLetterStore sets where dead jobs are parked.
+	SetDeadLetterStore(store DeadLetterStore)
+	// DeadLetters inspects, replays and purges the parked dead jobs.
+	DeadLetters() *DeadLetters
+}
+
+// GroupPusher fans out groups of jobs, see Workflow
+type GroupPusher interface {
+	// SetGroupStore enables PushGroup, the store tracks the members of the groups.
+	SetGroupStore(store GroupStore)
+	// PushGroup pushes members, then callback once they all finished.
+	PushGroup(ctx context.Context, callback *Job, members ...*Job) (gid string, err error)
+}
+
+// StatusTracker records the status of the jobs through their lifecycle
+type StatusTracker interface {
+	// SetStatusStore enables the job status tracking.
+	SetStatusStore(store *StatusStore)
+}
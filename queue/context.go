@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultReserveFor is how long a job may run when Job.ReserveFor is not set, in seconds, the same as faktory.
+const DefaultReserveFor = 1800
+
+type helperKey struct{}
+
+// JobContext returns the context a job runs with: it carries helper, and its deadline
+// is the job's reservation.
+func JobContext(parent context.Context, helper Helper, reserveFor int) (context.Context, context.CancelFunc) {
+	if reserveFor <= 0 {
+		reserveFor = DefaultReserveFor
+	}
+
+	ctx := context.WithValue(parent, helperKey{}, helper)
+	return context.WithTimeout(ctx, time.Duration(reserveFor)*time.Second)
+}
+
+// HelperFor returns the Helper of the job running with ctx.
+//
+// Caution: this method must only be called within the context of an
+// executing job. It will panic if it cannot find the Helper.
+func HelperFor(ctx context.Context) Helper {
+	if helper, ok := ctx.Value(helperKey{}).(Helper); ok {
+		return helper
+	}
+
+	panic("Invalid job context, cannot find the queue job helper")
+}
@@ -0,0 +1,14 @@
+package cron
+
+import "time"
+
+type CronConf struct {
+	Name      string        // namespaces the leader lock and the ticks in redis, usually the service name
+	Precision time.Duration `json:",default=1s"`  // how often due ticks are checked
+	Lease     time.Duration `json:",default=30s"` // a leader which stops refreshing its lock is replaced after the lease
+
+	// what to enqueue for the ticks missed while no replica was leading, e.g. during a deploy:
+	// skip: nothing, once: a single job, all: a job for every missed tick, up to MaxCatchUp
+	CatchUp    string `json:",default=once,options=skip|once|all"`
+	MaxCatchUp int    `json:",default=100"`
+}
@@ -0,0 +1,330 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	red "github.com/go-redis/redis/v8"
+	"github.com/robfig/cron/v3"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/cacher"
+	"github.com/toby1991/go-zero-utils/queue"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/service"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	CatchUpSkip = "skip"
+	CatchUpOnce = "once"
+	CatchUpAll  = "all"
+
+	leaderKey      = "leader"
+	lastKeyPrefix  = "last:" // unix milliseconds of the last tick enqueued by an entry
+	defaultCatchUp = CatchUpOnce
+)
+
+var (
+	ErrDuplicateEntry = errors.New("cron: duplicate entry name")
+
+	parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+	// claimScript moves the last tick of an entry forward, only if no other replica did it in the meantime
+	claimScript = bizredis.NewScript(`if (redis.call("GET", KEYS[1]) or "") ~= ARGV[1] then
+    return 0
+end
+redis.call("SET", KEYS[1], ARGV[2])
+return 1`)
+)
+
+// Entry pushes a copy of Job on every tick of Spec
+type Entry struct {
+	Name    string     // unique, the ticks of an entry are tracked by name
+	Spec    string     // "*/5 * * * *", seconds are optional, "@every 1h", "CRON_TZ=Asia/Shanghai 0 8 * * *"
+	Job     *queue.Job // template of the pushed jobs, they get a new jid and the custom keys cron / cron_at
+	CatchUp string     // overrides CronConf.CatchUp
+
+	schedule cron.Schedule
+}
+
+// ensure type compatibility
+var _ service.Service = &cronScheduler{}
+
+// cronScheduler pushes the jobs of its entries through a queue client, with redis only the replica
+// holding the leader lock pushes, and the ticks are claimed atomically, so a tick is pushed at most once.
+type cronScheduler struct {
+	_conf   CronConf
+	redis   bizredis.RedisClient // nil for a single replica
+	pusher  queue.Pusher
+	leader  *bizredis.RedisLock
+	entries []*Entry
+
+	mu   sync.Mutex
+	last map[string]string // last ticks without redis
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewCron pushes through pusher, usually a queue.Client, redis may be nil if a single replica runs the scheduler
+func NewCron(conf CronConf, redis bizredis.RedisClient, pusher queue.Pusher) *cronScheduler {
+	s := &cronScheduler{
+		_conf:  conf,
+		redis:  redis,
+		pusher: pusher,
+		last:   make(map[string]string),
+		stop:   make(chan struct{}),
+	}
+
+	if redis != nil {
+		s.leader = bizredis.NewRedisLock(redis, s.key(leaderKey))
+		lease := int(conf.Lease.Seconds())
+		if lease <= 0 {
+			lease = 30
+		}
+		s.leader.SetExpire(lease)
+	}
+
+	return s
+}
+
+// Add registers an entry, it must be called before Start
+func (s *cronScheduler) Add(entry Entry) error {
+	for _, e := range s.entries {
+		if e.Name == entry.Name {
+			return ErrDuplicateEntry
+		}
+	}
+
+	schedule, err := parser.Parse(entry.Spec)
+	if err != nil {
+		return fmt.Errorf("cron: invalid spec of %s: %w", entry.Name, err)
+	}
+	entry.schedule = schedule
+	if len(entry.CatchUp) <= 0 {
+		entry.CatchUp = s._conf.CatchUp
+	}
+	if len(entry.CatchUp) <= 0 {
+		entry.CatchUp = defaultCatchUp
+	}
+
+	s.entries = append(s.entries, &entry)
+	return nil
+}
+
+func (s *cronScheduler) Start() {
+	precision := s._conf.Precision
+	if precision <= 0 {
+		precision = time.Second
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(precision)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.tick(context.Background(), time.Now())
+			}
+		}
+	}()
+}
+
+func (s *cronScheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+
+	if s.leader != nil {
+		if _, err := s.leader.Release(); err != nil {
+			logx.Errorf("go-zero-utils: release cron leader: %v", err)
+		}
+	}
+}
+
+// tick pushes the due jobs of every entry, if this replica leads
+func (s *cronScheduler) tick(ctx context.Context, now time.Time) {
+	if s.leader != nil {
+		// acquiring the lock we hold refreshes it
+		leading, err := s.leader.AcquireCtx(ctx)
+		if err != nil || !leading {
+			return
+		}
+	}
+
+	for _, entry := range s.entries {
+		if err := s.run(ctx, entry, now); err != nil {
+			logx.Errorf("go-zero-utils: cron %s: %v", entry.Name, err)
+		}
+	}
+}
+
+// run claims the ticks of entry due by now, then pushes their jobs according to the catch-up policy
+func (s *cronScheduler) run(ctx context.Context, entry *Entry, now time.Time) error {
+	last, err := s.lastTick(ctx, entry.Name)
+	if err != nil {
+		return err
+	}
+
+	// first run, start from now
+	if len(last) <= 0 {
+		_, err := s.claim(ctx, entry.Name, last, formatTick(now))
+		return err
+	}
+
+	lastAt, err := parseTick(last)
+	if err != nil {
+		return err
+	}
+
+	ticks := s.dueTicks(entry, lastAt, now)
+	if len(ticks) <= 0 {
+		return nil
+	}
+
+	claimedTick := formatTick(ticks[len(ticks)-1])
+	claimed, err := s.claim(ctx, entry.Name, last, claimedTick)
+	if err != nil || !claimed {
+		return err
+	}
+
+	pushed := last
+	for _, at := range s.catchUp(entry, ticks, now) {
+		if err := s.push(entry, at); err != nil {
+			// give back the ticks not pushed, the next check retries them
+			if _, err := s.claim(ctx, entry.Name, claimedTick, pushed); err != nil {
+				logx.Errorf("go-zero-utils: cron %s: unclaim: %v", entry.Name, err)
+			}
+			return err
+		}
+		pushed = formatTick(at)
+	}
+	return nil
+}
+
+// dueTicks returns the ticks after last up to now, at most MaxCatchUp of the most recent ones are kept
+// for the all policy, only the most recent one otherwise.
+func (s *cronScheduler) dueTicks(entry *Entry, last, now time.Time) []time.Time {
+	keep := 1
+	if entry.CatchUp == CatchUpAll {
+		keep = s._conf.MaxCatchUp
+		if keep <= 0 {
+			keep = 1
+		}
+	}
+
+	ticks := make([]time.Time, 0, 1)
+	for at := entry.schedule.Next(last); !at.After(now) && !at.IsZero(); at = entry.schedule.Next(at) {
+		ticks = append(ticks, at)
+		if len(ticks) > keep {
+			ticks = ticks[1:]
+		}
+	}
+	return ticks
+}
+
+// catchUp selects the ticks to push among the due ones
+func (s *cronScheduler) catchUp(entry *Entry, ticks []time.Time, now time.Time) []time.Time {
+	precision := s._conf.Precision
+	if precision <= 0 {
+		precision = time.Second
+	}
+
+	latest := ticks[len(ticks)-1]
+	switch entry.CatchUp {
+	case CatchUpAll:
+		return ticks
+	case CatchUpSkip:
+		// only the tick due since the previous check, missed ones are skipped
+		if now.Sub(latest) <= 2*precision {
+			return []time.Time{latest}
+		}
+		logx.Infof("go-zero-utils: cron %s skipped ticks up to %s", entry.Name, latest.Format(time.RFC3339))
+		return nil
+	default:
+		return []time.Time{latest}
+	}
+}
+
+// push enqueues a copy of the entry job for the tick at
+func (s *cronScheduler) push(entry *Entry, at time.Time) error {
+	jobJsonBytes, err := entry.Job.JsonBytes()
+	if err != nil {
+		return err
+	}
+
+	var job queue.Job
+	if err := json.Unmarshal(jobJsonBytes, &job); err != nil {
+		return err
+	}
+	job.Jid = queue.RandomJid()
+	job.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	job.SetCustom("cron", entry.Name)
+	job.SetCustom("cron_at", at.UTC().Format(time.RFC3339))
+
+	return s.pusher.Push(&job)
+}
+
+func (s *cronScheduler) lastTick(ctx context.Context, name string) (string, error) {
+	if s.redis == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return s.last[name], nil
+	}
+
+	last, err := s.redis.Client().Get(ctx, cacher.NewKey(s.key(lastKeyPrefix+name), s.redis.Prefix()).Prefixed()).Result()
+	if err == red.Nil {
+		return "", nil
+	}
+	return last, err
+}
+
+// claim moves the last tick of name from last to tick, it returns false if another replica moved it first
+func (s *cronScheduler) claim(ctx context.Context, name string, last string, tick string) (bool, error) {
+	if s.redis == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.last[name] != last {
+			return false, nil
+		}
+		s.last[name] = tick
+		return true, nil
+	}
+
+	resp, err := s.redis.ScriptRunCtx(ctx, claimScript, []string{s.key(lastKeyPrefix + name)}, last, tick)
+	if err != nil {
+		return false, err
+	}
+	claimed, _ := resp.(int64)
+	return claimed == 1, nil
+}
+
+// key namespaces raw by CronConf.Name, the schedulers of different services do not share their leader and ticks
+func (s *cronScheduler) key(raw string) string {
+	if len(s._conf.Name) <= 0 {
+		return "cron:" + raw
+	}
+	return "cron:" + s._conf.Name + ":" + raw
+}
+
+func formatTick(at time.Time) string {
+	return strconv.FormatInt(at.UnixMilli(), 10)
+}
+
+func parseTick(tick string) (time.Time, error) {
+	millis, err := strconv.ParseInt(tick, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cron: invalid last tick %q", tick)
+	}
+	return time.UnixMilli(millis), nil
+}
@@ -0,0 +1,145 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/queue"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type pusher struct {
+	jobs  []*queue.Job
+	fails []bool // the result of the next pushes, true to fail
+}
+
+func (p *pusher) Push(job *queue.Job) error {
+	if len(p.fails) > 0 {
+		fail := p.fails[0]
+		p.fails = p.fails[1:]
+		if fail {
+			return errors.New("push failed")
+		}
+	}
+	p.jobs = append(p.jobs, job)
+	return nil
+}
+
+func Test_cronScheduler_run(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		catchUp    string
+		maxCatchUp int
+		now        time.Time
+		wantAt     []time.Time
+	}{
+		{name: "not due", catchUp: CatchUpOnce, now: start.Add(30 * time.Second)},
+		{name: "on time", catchUp: CatchUpSkip, now: start.Add(time.Minute + 500*time.Millisecond), wantAt: []time.Time{start.Add(time.Minute)}},
+		{name: "missed skip", catchUp: CatchUpSkip, now: start.Add(3*time.Minute + 30*time.Second)},
+		{name: "missed once", catchUp: CatchUpOnce, now: start.Add(3*time.Minute + 30*time.Second), wantAt: []time.Time{start.Add(3 * time.Minute)}},
+		{name: "missed all", catchUp: CatchUpAll, now: start.Add(3*time.Minute + 30*time.Second), wantAt: []time.Time{start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)}},
+		{name: "missed all capped", catchUp: CatchUpAll, maxCatchUp: 2, now: start.Add(10*time.Minute + 30*time.Second), wantAt: []time.Time{start.Add(9 * time.Minute), start.Add(10 * time.Minute)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pusher{}
+			maxCatchUp := tt.maxCatchUp
+			if maxCatchUp <= 0 {
+				maxCatchUp = 100
+			}
+			s := NewCron(CronConf{Precision: time.Second, MaxCatchUp: maxCatchUp}, nil, p)
+			if err := s.Add(Entry{Name: "kline", Spec: "@every 1m", Job: queue.NewJob("kline_filling", 1), CatchUp: tt.catchUp}); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+
+			ctx := context.Background()
+			s.run(ctx, s.entries[0], start) // first run, starts the schedule
+			if err := s.run(ctx, s.entries[0], tt.now); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			// ticks are claimed, running again does not push them twice
+			if err := s.run(ctx, s.entries[0], tt.now); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+
+			if len(p.jobs) != len(tt.wantAt) {
+				t.Fatalf("pushed %d jobs, want %d", len(p.jobs), len(tt.wantAt))
+			}
+			for i, job := range p.jobs {
+				at, _ := job.GetCustom("cron_at")
+				if at != tt.wantAt[i].Format(time.RFC3339) || job.Type != "kline_filling" {
+					t.Errorf("job %d = %s at %v, want kline_filling at %s", i, job.Type, at, tt.wantAt[i].Format(time.RFC3339))
+				}
+			}
+		})
+	}
+}
+
+func Test_cronScheduler_pushFailed(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		catchUp string
+		now     time.Time
+		fails   []bool
+		wantAt  []time.Time
+	}{
+		{name: "skip", catchUp: CatchUpSkip, now: start.Add(time.Minute), fails: []bool{true}, wantAt: []time.Time{start.Add(time.Minute)}},
+		{name: "once", catchUp: CatchUpOnce, now: start.Add(3 * time.Minute), fails: []bool{true}, wantAt: []time.Time{start.Add(3 * time.Minute)}},
+		{name: "all", catchUp: CatchUpAll, now: start.Add(3 * time.Minute), fails: []bool{false, true}, wantAt: []time.Time{start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pusher{}
+			s := NewCron(CronConf{Precision: time.Second, MaxCatchUp: 100}, nil, p)
+			if err := s.Add(Entry{Name: "kline", Spec: "@every 1m", Job: queue.NewJob("kline_filling", 1), CatchUp: tt.catchUp}); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+
+			ctx := context.Background()
+			s.run(ctx, s.entries[0], start)
+			p.fails = tt.fails
+			if err := s.run(ctx, s.entries[0], tt.now); err == nil {
+				t.Fatal("run() error = nil, want the push error")
+			}
+			// the next check pushes the ticks given back
+			if err := s.run(ctx, s.entries[0], tt.now.Add(time.Second)); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+
+			if len(p.jobs) != len(tt.wantAt) {
+				t.Fatalf("pushed %d jobs, want %d", len(p.jobs), len(tt.wantAt))
+			}
+			for i, job := range p.jobs {
+				if at, _ := job.GetCustom("cron_at"); at != tt.wantAt[i].Format(time.RFC3339) {
+					t.Errorf("job %d at %v, want %s", i, at, tt.wantAt[i].Format(time.RFC3339))
+				}
+			}
+		})
+	}
+}
+
+func Test_cronScheduler_leader(t *testing.T) {
+	redis := miniredis.RunT(t)
+	port, _ := strconv.Atoi(redis.Port())
+	newCron := func(name string) *cronScheduler {
+		return NewCron(CronConf{Name: name}, bizredis.NewRedis(bizredis.BizRedisConf{Host: redis.Host(), Port: port}), &pusher{})
+	}
+
+	// every service leads its own scheduler
+	ctx := context.Background()
+	for _, s := range []*cronScheduler{newCron("goods"), newCron("orders")} {
+		if leading, err := s.leader.AcquireCtx(ctx); err != nil || !leading {
+			t.Errorf("%s leading = %v, error = %v, want it leading", s._conf.Name, leading, err)
+		}
+	}
+	if leading, _ := newCron("goods").leader.AcquireCtx(ctx); leading {
+		t.Error("two replicas of a service lead")
+	}
+}
@@ -0,0 +1,253 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrDeadJobNotFound     = errors.New("go-zero-utils: dead job not found")
+	ErrNoDeadLetterStore   = errors.New("go-zero-utils: no dead letter store")
+	ErrDeadJobWithoutQueue = errors.New("go-zero-utils: dead job without queue")
+)
+
+const (
+	DlqReprocess = "reprocess" // dead jobs are moved to "<queue>-dlq" and run once more, they are parked if they fail again
+	DlqPark      = "park"      // dead jobs are parked in the DeadLetterStore until replayed or purged
+)
+
+// DlqConf chooses what happens to the dead jobs of every queue
+type DlqConf struct {
+	Mode   string            `json:",default=reprocess,options=reprocess|park"`
+	Queues map[string]string `json:",optional"` // mode by queue, e.g. {"payment":"park"}
+}
+
+// ModeFor returns the dlq mode of queue, DlqReprocess unless configured otherwise
+func (c DlqConf) ModeFor(queue string) string {
+	if mode, ok := c.Queues[queue]; ok && len(mode) > 0 {
+		return mode
+	}
+	if len(c.Mode) > 0 {
+		return c.Mode
+	}
+	return DlqReprocess
+}
+
+// DeadLetterStore keeps dead jobs, with their Failure, until they are replayed or purged
+type DeadLetterStore interface {
+	Add(ctx context.Context, job *Job) error
+	// List returns the dead jobs of queue, the most recent first
+	List(ctx context.Context, queue string, offset, limit int64) ([]*Job, error)
+	Count(ctx context.Context, queue string) (int64, error)
+	// Get returns ErrDeadJobNotFound if jid is not dead
+	Get(ctx context.Context, jid string) (*Job, error)
+	Remove(ctx context.Context, jid string) error
+	// Purge removes every dead job of queue, and returns how many were removed
+	Purge(ctx context.Context, queue string) (int64, error)
+}
+
+// Pusher is the producing side of a Client
+type Pusher interface {
+	Push(job *Job) error
+}
+
+// DeadLetters inspects, replays and purges the dead jobs parked in a DeadLetterStore
+type DeadLetters struct {
+	store  DeadLetterStore
+	pusher Pusher
+}
+
+// NewDeadLetters replays dead jobs of store through pusher, usually the client which parked them
+func NewDeadLetters(store DeadLetterStore, pusher Pusher) *DeadLetters {
+	return &DeadLetters{store: store, pusher: pusher}
+}
+
+func (d *DeadLetters) List(ctx context.Context, queue string, offset, limit int64) ([]*Job, error) {
+	if d == nil || d.store == nil {
+		return nil, ErrNoDeadLetterStore
+	}
+	return d.store.List(ctx, queue, offset, limit)
+}
+func (d *DeadLetters) Count(ctx context.Context, queue string) (int64, error) {
+	if d == nil || d.store == nil {
+		return 0, ErrNoDeadLetterStore
+	}
+	return d.store.Count(ctx, queue)
+}
+func (d *DeadLetters) Get(ctx context.Context, jid string) (*Job, error) {
+	if d == nil || d.store == nil {
+		return nil, ErrNoDeadLetterStore
+	}
+	return d.store.Get(ctx, jid)
+}
+
+// Replay pushes the dead job back to its queue with a clean Failure, so it gets its retries again,
+// then removes it from the store.
+func (d *DeadLetters) Replay(ctx context.Context, jid string) error {
+	job, err := d.Get(ctx, jid)
+	if err != nil {
+		return err
+	}
+
+	job.Failure = nil
+	job.At = ""
+	if err := d.pusher.Push(job); err != nil {
+		return err
+	}
+
+	return d.store.Remove(ctx, jid)
+}
+
+// ReplayAll replays every dead job of queue, it stops at the first error and returns how many were replayed
+func (d *DeadLetters) ReplayAll(ctx context.Context, queue string) (int, error) {
+	const batchSize = 100
+
+	replayed := 0
+	for {
+		// replayed jobs are removed, so the first page is always the next one
+		jobs, err := d.List(ctx, queue, 0, batchSize)
+		if err != nil {
+			return replayed, err
+		}
+		if len(jobs) <= 0 {
+			return replayed, nil
+		}
+
+		for _, job := range jobs {
+			if err := d.Replay(ctx, job.Jid); err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+	}
+}
+
+// Delete removes a single dead job without replaying it
+func (d *DeadLetters) Delete(ctx context.Context, jid string) error {
+	if d == nil || d.store == nil {
+		return ErrNoDeadLetterStore
+	}
+	return d.store.Remove(ctx, jid)
+}
+
+// Purge removes every dead job of queue without replaying them
+func (d *DeadLetters) Purge(ctx context.Context, queue string) (int64, error) {
+	if d == nil || d.store == nil {
+		return 0, ErrNoDeadLetterStore
+	}
+	return d.store.Purge(ctx, queue)
+}
+
+// ensure type compatibility
+var _ DeadLetterStore = &memoryDeadLetterStore{}
+
+// memoryDeadLetterStore keeps dead jobs in-process, dead jobs are lost when the process exits
+type memoryDeadLetterStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job // jid => job
+	order int64
+	added map[string]int64 // jid => order it was added in
+}
+
+func NewMemoryDeadLetterStore() *memoryDeadLetterStore {
+	return &memoryDeadLetterStore{
+		jobs:  make(map[string]*Job),
+		added: make(map[string]int64),
+	}
+}
+
+func (s *memoryDeadLetterStore) Add(ctx context.Context, job *Job) error {
+	if len(job.Queue) <= 0 {
+		return ErrDeadJobWithoutQueue
+	}
+
+	jobJsonBytes, err := job.JsonBytes()
+	if err != nil {
+		return err
+	}
+	var stored Job
+	if err := json.Unmarshal(jobJsonBytes, &stored); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.order++
+	s.jobs[job.Jid] = &stored
+	s.added[job.Jid] = s.order
+	return nil
+}
+func (s *memoryDeadLetterStore) List(ctx context.Context, queue string, offset, limit int64) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := s.queueJobs(queue)
+	if offset >= int64(len(jobs)) {
+		return nil, nil
+	}
+	jobs = jobs[offset:]
+	if limit > 0 && limit < int64(len(jobs)) {
+		jobs = jobs[:limit]
+	}
+
+	list := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		cloned := *job
+		list = append(list, &cloned)
+	}
+	return list, nil
+}
+func (s *memoryDeadLetterStore) Count(ctx context.Context, queue string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.queueJobs(queue))), nil
+}
+func (s *memoryDeadLetterStore) Get(ctx context.Context, jid string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[jid]
+	if !ok {
+		return nil, ErrDeadJobNotFound
+	}
+	cloned := *job
+	return &cloned, nil
+}
+func (s *memoryDeadLetterStore) Remove(ctx context.Context, jid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, jid)
+	delete(s.added, jid)
+	return nil
+}
+func (s *memoryDeadLetterStore) Purge(ctx context.Context, queue string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := s.queueJobs(queue)
+	for _, job := range jobs {
+		delete(s.jobs, job.Jid)
+		delete(s.added, job.Jid)
+	}
+	return int64(len(jobs)), nil
+}
+
+// queueJobs returns the dead jobs of queue, the most recent first, s.mu must be held
+func (s *memoryDeadLetterStore) queueJobs(queue string) []*Job {
+	jobs := make([]*Job, 0)
+	for _, job := range s.jobs {
+		if job.Queue == queue {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return s.added[jobs[i].Jid] > s.added[jobs[j].Jid]
+	})
+	return jobs
+}
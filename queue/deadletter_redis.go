@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/cacher"
+	"time"
+)
+
+const (
+	deadJobsKey        = "queue:dead:jobs"   // hash of jid => dead job
+	deadQueueKeyPrefix = "queue:dead:queue:" // sorted set of the jids of a queue, scored by death time
+)
+
+// purgeScript removes every job of a queue atomically
+var purgeScript = bizredis.NewScript(`local jids = redis.call("ZRANGE", KEYS[1], 0, -1)
+for i = 1, #jids, 1000 do
+    redis.call("HDEL", KEYS[2], unpack(jids, i, math.min(i + 999, #jids)))
+end
+redis.call("DEL", KEYS[1])
+return #jids`)
+
+// ensure type compatibility
+var _ DeadLetterStore = &redisDeadLetterStore{}
+
+// redisDeadLetterStore keeps dead jobs in redis, shared by every replica
+type redisDeadLetterStore struct {
+	redis bizredis.RedisClient
+}
+
+func NewRedisDeadLetterStore(redis bizredis.RedisClient) *redisDeadLetterStore {
+	return &redisDeadLetterStore{redis: redis}
+}
+
+func (s *redisDeadLetterStore) key(raw string) string {
+	return cacher.NewKey(raw, s.redis.Prefix()).Prefixed()
+}
+
+func (s *redisDeadLetterStore) Add(ctx context.Context, job *Job) error {
+	if len(job.Queue) <= 0 {
+		return ErrDeadJobWithoutQueue
+	}
+
+	jobJsonBytes, err := job.JsonBytes()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.key(deadJobsKey), job.Jid, jobJsonBytes)
+		pipe.ZAdd(ctx, s.key(deadQueueKeyPrefix+job.Queue), &redis.Z{
+			Score:  float64(time.Now().UnixMilli()),
+			Member: job.Jid,
+		})
+		return nil
+	})
+	return err
+}
+func (s *redisDeadLetterStore) List(ctx context.Context, queue string, offset, limit int64) ([]*Job, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = offset + limit - 1
+	}
+
+	jids, err := s.redis.Client().ZRevRange(ctx, s.key(deadQueueKeyPrefix+queue), offset, stop).Result()
+	if err != nil || len(jids) <= 0 {
+		return nil, err
+	}
+
+	values, err := s.redis.Client().HMGet(ctx, s.key(deadJobsKey), jids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(values))
+	for _, value := range values {
+		jobJson, ok := value.(string)
+		if !ok {
+			continue // removed in the meantime
+		}
+
+		var job Job
+		if err := json.Unmarshal([]byte(jobJson), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+func (s *redisDeadLetterStore) Count(ctx context.Context, queue string) (int64, error) {
+	return s.redis.Client().ZCard(ctx, s.key(deadQueueKeyPrefix+queue)).Result()
+}
+func (s *redisDeadLetterStore) Get(ctx context.Context, jid string) (*Job, error) {
+	jobJson, err := s.redis.Client().HGet(ctx, s.key(deadJobsKey), jid).Result()
+	if err == redis.Nil {
+		return nil, ErrDeadJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(jobJson), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+func (s *redisDeadLetterStore) Remove(ctx context.Context, jid string) error {
+	job, err := s.Get(ctx, jid)
+	if err == ErrDeadJobNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.key(deadJobsKey), jid)
+		pipe.ZRem(ctx, s.key(deadQueueKeyPrefix+job.Queue), jid)
+		return nil
+	})
+	return err
+}
+func (s *redisDeadLetterStore) Purge(ctx context.Context, queue string) (int64, error) {
+	resp, err := s.redis.ScriptRunCtx(ctx, purgeScript, []string{deadQueueKeyPrefix + queue, deadJobsKey})
+	if err != nil {
+		return 0, err
+	}
+
+	purged, _ := resp.(int64)
+	return purged, nil
+}
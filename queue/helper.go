@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"errors"
 	faktory "github.com/contribsys/faktory/client"
 	"time"
 )
@@ -47,3 +48,6 @@ type Helper interface {
 	//
 	TrackProgress(percent int, desc string, reserveUntil *time.Time) error
 }
+
+// ErrUnsupported is returned by Helper methods the queue backend can not provide.
+var ErrUnsupported = errors.New("go-zero-utils: not supported by this queue backend")
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"github.com/zeromicro/go-zero/core/logx"
+	"sync"
+	"time"
+)
+
+const inflightPollInterval = 50 * time.Millisecond
+
+// Inflight tracks the jobs being processed by a client, so it can drain them on shutdown.
+type Inflight struct {
+	mu   sync.Mutex
+	jobs map[*Job]struct{}
+}
+
+func (i *Inflight) Add(job *Job) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.jobs == nil {
+		i.jobs = make(map[*Job]struct{})
+	}
+	i.jobs[job] = struct{}{}
+}
+
+func (i *Inflight) Done(job *Job) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.jobs, job)
+}
+
+// Jobs returns the jobs being processed.
+func (i *Inflight) Jobs() []*Job {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	jobs := make([]*Job, 0, len(i.jobs))
+	for job := range i.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// Wait waits until every job is done or timeout elapses, it returns the jobs still in flight.
+func (i *Inflight) Wait(timeout time.Duration) []*Job {
+	deadline := time.Now().Add(timeout)
+	for {
+		jobs := i.Jobs()
+		if len(jobs) <= 0 || !time.Now().Before(deadline) {
+			return jobs
+		}
+
+		time.Sleep(inflightPollInterval)
+	}
+}
+
+// ReportAbandoned logs the jobs cut off by a shutdown, the broker will deliver them again
+// depending on its own semantics, e.g. once their message or reservation times out.
+func ReportAbandoned(jobs []*Job) {
+	for _, job := range jobs {
+		logx.Errorw("go-zero-utils: job abandoned on shutdown",
+			logx.Field("jid", job.Jid),
+			logx.Field("jobtype", job.Type),
+			logx.Field("queue", job.Queue),
+		)
+	}
+}
@@ -2,14 +2,20 @@ package queue
 
 import (
 	cryptorand "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	mathrand "math/rand"
 	"time"
 )
 
 type UniqueUntil string
 
+// ErrNotUnique is returned by Push when a unique job is already enqueued.
+var ErrNotUnique = errors.New("go-zero-utils: job is not unique")
+
 var (
 	RetryPolicyDefault        = 25
 	RetryPolicyEmphemeral     = 0
@@ -127,6 +133,89 @@ func (j *Job) SetExpiresIn(expiresIn time.Duration) *Job {
 	return j.SetCustom("expires_at", time.Now().Add(expiresIn).Format(time.RFC3339Nano))
 }
 
+// ExpiresAt returns the TTL configured by SetExpiresAt or SetExpiresIn.
+func (j *Job) ExpiresAt() (time.Time, bool) {
+	val, ok := j.GetCustom("expires_at")
+	if !ok {
+		return time.Time{}, false
+	}
+	str, ok := val.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return expiresAt, true
+}
+
+// Expired reports whether the job should be discarded rather than executed.
+func (j *Job) Expired() bool {
+	expiresAt, ok := j.ExpiresAt()
+	return ok && time.Now().After(expiresAt)
+}
+
+// UniqueFor returns the uniqueness TTL configured by SetUniqueFor, 0 if the job is not unique.
+func (j *Job) UniqueFor() time.Duration {
+	val, ok := j.GetCustom("unique_for")
+	if !ok {
+		return 0
+	}
+
+	// uint before the job is pushed, float64 once it is decoded from json
+	switch secs := val.(type) {
+	case uint:
+		return time.Duration(secs) * time.Second
+	case int:
+		return time.Duration(secs) * time.Second
+	case float64:
+		return time.Duration(secs * float64(time.Second))
+	default:
+		return 0
+	}
+}
+
+// UniqueUntil returns the uniqueness deadline configured by SetUniqueness, UntilSuccess by default.
+func (j *Job) UniqueUntil() UniqueUntil {
+	val, ok := j.GetCustom("unique_until")
+	if !ok {
+		return UntilSuccess
+	}
+
+	switch until := val.(type) {
+	case UniqueUntil:
+		return until
+	case string:
+		return UniqueUntil(until)
+	default:
+		return UntilSuccess
+	}
+}
+
+// UniqueKey identifies a unique job by its queue, type and args.
+//
+// It is computed once when the job is pushed and kept in the custom hash,
+// since args decoded by the worker do not marshal exactly like the pushed ones.
+func (j *Job) UniqueKey() (string, error) {
+	if val, ok := j.GetCustom("unique_key"); ok {
+		if key, ok := val.(string); ok {
+			return key, nil
+		}
+	}
+
+	data, err := json.Marshal([]interface{}{j.Queue, j.Type, j.Args})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+
+	j.SetCustom("unique_key", key)
+	return key, nil
+}
+
 func (j *Job) JsonBytes() ([]byte, error) {
 	return json.Marshal(j)
 }
@@ -0,0 +1,172 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/zeromicro/go-zero/core/logx"
+	mathrand "math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	limitConcurrencyKeyPrefix = "queue:limit:concurrency:"
+	limitRateKeyPrefix        = "queue:limit:rate:"
+)
+
+var (
+	// concurrencyAcquireScript takes a slot of the job type, the slots of dead workers expire after the lease
+	concurrencyAcquireScript = bizredis.NewScript(`redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZSCORE", KEYS[1], ARGV[3]) or redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[4]) then
+    redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+    redis.call("PEXPIRE", KEYS[1], ARGV[5])
+    return 1
+end
+return 0`)
+	concurrencyReleaseScript = bizredis.NewScript(`return redis.call("ZREM", KEYS[1], ARGV[1])`)
+
+	// rateScript counts the starts of the current window, it returns 0 if allowed, the milliseconds left in the window otherwise,
+	// the rejected attempts are not counted
+	rateScript = bizredis.NewScript(`if tonumber(redis.call("GET", KEYS[1]) or "0") >= tonumber(ARGV[1]) then
+    local ttl = redis.call("PTTL", KEYS[1])
+    if ttl < 0 then
+        redis.call("PEXPIRE", KEYS[1], ARGV[2])
+        ttl = tonumber(ARGV[2])
+    end
+    return ttl
+end
+if redis.call("INCR", KEYS[1]) == 1 then
+    redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// LimitConf limits a job type across every worker sharing the redis, see Limit
+type LimitConf struct {
+	Concurrency int           `json:",optional"`    // max jobs running at once, 0 for no limit
+	Rate        int           `json:",optional"`    // max jobs started per Interval, 0 for no limit
+	Interval    time.Duration `json:",default=1s"`  // window of Rate
+	Lease       time.Duration `json:",default=30m"` // a slot not released within the lease, e.g. its worker died, is freed
+	Snooze      time.Duration `json:",default=5s"`  // how long a job over Concurrency is delayed, with up to 10% jitter
+}
+
+type snoozeError struct {
+	delay time.Duration
+}
+
+func (e *snoozeError) Error() string {
+	return fmt.Sprintf("go-zero-utils: job snoozed for %s", e.delay)
+}
+
+// Snooze requeues the job to run again after delay, it is neither a failure nor a retry:
+// the retry count is untouched and the job keeps its place in its batch.
+func Snooze(delay time.Duration) error {
+	return &snoozeError{delay: delay}
+}
+
+// SnoozeDelay reports whether err was returned by Snooze, and its delay
+func SnoozeDelay(err error) (time.Duration, bool) {
+	var snooze *snoozeError
+	if errors.As(err, &snooze) {
+		return snooze.delay, true
+	}
+	return 0, false
+}
+
+// Limit enforces limits by job type through redis, a job over its limit is snoozed instead of failed.
+// Register it by UseFirst, ahead of the Logging registered by the clients, so the snoozed jobs do not run
+// the other middlewares. Only the jobs let through count against Rate, the snoozed ones come back in a later window.
+//
+//	client.UseFirst(queue.Limit(redis, map[string]queue.LimitConf{
+//	    "kline_filling": {Concurrency: 5, Rate: 100, Interval: time.Minute},
+//	}))
+func Limit(redis bizredis.RedisScripter, limits map[string]LimitConf) Middleware {
+	return func(next JobProcessor) JobProcessor {
+		return func(helper Helper, args ...interface{}) error {
+			conf, ok := limits[helper.JobType()]
+			if !ok {
+				return next(helper, args...)
+			}
+
+			ctx := context.Background()
+			if conf.Concurrency > 0 {
+				acquired, err := acquireConcurrency(ctx, redis, helper, conf)
+				if err != nil {
+					return err
+				}
+				if !acquired {
+					return Snooze(jitter(conf.Snooze))
+				}
+				defer releaseConcurrency(ctx, redis, helper)
+			}
+
+			if conf.Rate > 0 {
+				wait, err := takeRate(ctx, redis, helper, conf)
+				if err != nil {
+					return err
+				}
+				if wait > 0 {
+					return Snooze(jitter(wait))
+				}
+			}
+
+			return next(helper, args...)
+		}
+	}
+}
+
+func acquireConcurrency(ctx context.Context, redis bizredis.RedisScripter, helper Helper, conf LimitConf) (bool, error) {
+	lease := conf.Lease
+	if lease <= 0 {
+		lease = DefaultReserveFor * time.Second
+	}
+
+	now := time.Now()
+	resp, err := redis.ScriptRunCtx(ctx, concurrencyAcquireScript, []string{limitConcurrencyKeyPrefix + helper.JobType()},
+		strconv.FormatInt(now.UnixMilli(), 10),
+		strconv.FormatInt(now.Add(lease).UnixMilli(), 10),
+		helper.Jid(),
+		strconv.Itoa(conf.Concurrency),
+		strconv.FormatInt(lease.Milliseconds(), 10),
+	)
+	if err != nil {
+		return false, err
+	}
+	acquired, _ := resp.(int64)
+	return acquired == 1, nil
+}
+
+func releaseConcurrency(ctx context.Context, redis bizredis.RedisScripter, helper Helper) {
+	if _, err := redis.ScriptRunCtx(ctx, concurrencyReleaseScript, []string{limitConcurrencyKeyPrefix + helper.JobType()}, helper.Jid()); err != nil {
+		logx.Errorf("go-zero-utils: release concurrency slot of job %s: %v", helper.Jid(), err)
+	}
+}
+
+// takeRate returns how long to wait for the next window, 0 if the job may start now
+func takeRate(ctx context.Context, redis bizredis.RedisScripter, helper Helper, conf LimitConf) (time.Duration, error) {
+	interval := conf.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	resp, err := redis.ScriptRunCtx(ctx, rateScript, []string{limitRateKeyPrefix + helper.JobType()},
+		strconv.Itoa(conf.Rate),
+		strconv.FormatInt(interval.Milliseconds(), 10),
+	)
+	if err != nil {
+		return 0, err
+	}
+	wait, _ := resp.(int64)
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// jitter adds up to 10% to delay, so the snoozed jobs do not come back all at once
+func jitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		delay = 5 * time.Second
+	}
+	//nolint:gosec
+	return delay + time.Duration(mathrand.Int63n(int64(delay)/10+1))
+}
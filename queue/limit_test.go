@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// limitScripter answers the limit scripts without redis
+type limitScripter struct {
+	bizredis.RedisScripter
+	slots    int   // concurrency slots taken
+	wait     int64 // rateScript response
+	released int
+}
+
+func (s *limitScripter) ScriptRunCtx(ctx context.Context, script *bizredis.Script, keys []string, args ...any) (any, error) {
+	switch script {
+	case concurrencyAcquireScript:
+		if s.slots >= 1 {
+			return int64(0), nil
+		}
+		s.slots++
+		return int64(1), nil
+	case concurrencyReleaseScript:
+		s.slots--
+		s.released++
+		return int64(1), nil
+	default:
+		return s.wait, nil
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		conf         LimitConf
+		slots        int
+		wait         int64
+		wantRun      bool
+		wantSnooze   time.Duration // minimum delay
+		wantReleased int
+	}{
+		{name: "not limited", wantRun: true},
+		{name: "under concurrency", conf: LimitConf{Concurrency: 1}, wantRun: true, wantReleased: 1},
+		{name: "over concurrency", conf: LimitConf{Concurrency: 1, Snooze: time.Second}, slots: 1, wantSnooze: time.Second},
+		{name: "under rate", conf: LimitConf{Rate: 10}, wantRun: true},
+		{name: "over rate", conf: LimitConf{Rate: 10}, wait: 300, wantSnooze: 300 * time.Millisecond},
+		{name: "over rate releases the slot", conf: LimitConf{Concurrency: 1, Rate: 10}, wait: 300, wantSnooze: 300 * time.Millisecond, wantReleased: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &limitScripter{slots: tt.slots, wait: tt.wait}
+			limits := map[string]LimitConf{}
+			if tt.conf != (LimitConf{}) {
+				limits["kline_filling"] = tt.conf
+			}
+
+			ran := false
+			err := Limit(s, limits)(func(helper Helper, args ...interface{}) error {
+				ran = true
+				return nil
+			})(&testHelper{jid: "1", jobType: "kline_filling"})
+
+			if ran != tt.wantRun {
+				t.Errorf("ran = %v, want %v", ran, tt.wantRun)
+			}
+			delay, snoozed := SnoozeDelay(err)
+			if snoozed != (tt.wantSnooze > 0) || delay < tt.wantSnooze || delay > tt.wantSnooze*11/10 {
+				t.Errorf("err = %v, want snooze %s", err, tt.wantSnooze)
+			}
+			if s.released != tt.wantReleased {
+				t.Errorf("released = %d, want %d", s.released, tt.wantReleased)
+			}
+		})
+	}
+}
+
+func TestLimit_rate(t *testing.T) {
+	redis := miniredis.RunT(t)
+	port, _ := strconv.Atoi(redis.Port())
+	limit := Limit(bizredis.NewRedis(bizredis.BizRedisConf{Host: redis.Host(), Port: port}), map[string]LimitConf{
+		"kline_filling": {Rate: 2, Interval: time.Minute},
+	})
+
+	runs := 0
+	processor := limit(func(helper Helper, args ...interface{}) error {
+		runs++
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		_ = processor(&testHelper{jid: strconv.Itoa(i), jobType: "kline_filling"})
+	}
+	if runs != 2 {
+		t.Errorf("runs = %d, want 2", runs)
+	}
+
+	// the snoozed attempts are not counted, the next window lets Rate jobs through again
+	if got, _ := redis.Get(limitRateKeyPrefix + "kline_filling"); got != "2" {
+		t.Errorf("count = %s, want 2", got)
+	}
+	redis.FastForward(time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := processor(&testHelper{jid: "next", jobType: "kline_filling"}); err != nil {
+			t.Errorf("next window: err = %v", err)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"github.com/toby1991/go-zero-utils/queue"
+	"time"
+)
+
+type MemoryConf struct {
+	Concurrency     int           `json:",default=20"`
+	RetryBackoff    time.Duration `json:",default=15s"` // first retry delay, doubled on every retry
+	MaxRetryBackoff time.Duration `json:",default=1h"`
+	ShutdownTimeout time.Duration `json:",default=30s"` // how long Stop waits for in-flight jobs
+	Dlq             queue.DlqConf
+}
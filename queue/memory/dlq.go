@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/toby1991/go-zero-utils/queue"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// the same as nsq, dead jobs are moved to "<queue>-dlq" or parked, see queue.DlqConf
+const TOPIC_DLQ_SUFFIX = "-dlq"
+
+type dlq struct {
+	client *memoryClient
+	conf   queue.DlqConf
+	store  queue.DeadLetterStore
+}
+
+func newDlq(client *memoryClient, conf queue.DlqConf) *dlq {
+	return &dlq{client: client, conf: conf, store: queue.NewMemoryDeadLetterStore()}
+}
+
+func (d *dlq) RequeueDeadJob(job *queue.Job) error {
+	jobJsonBytes, err := job.JsonBytes()
+	if err != nil {
+		return err
+	}
+
+	logx.Alert(fmt.Sprintf("go-zero-utils: RequeueDeadJob: %s", string(jobJsonBytes)))
+
+	if d.conf.ModeFor(job.Queue) == queue.DlqPark {
+		return d.Park(job)
+	}
+
+	d.client.enqueue(job.Queue+TOPIC_DLQ_SUFFIX, job)
+	return nil
+}
+
+// Park stores the dead job until it is replayed or purged
+func (d *dlq) Park(job *queue.Job) error {
+	if d.store == nil {
+		return queue.ErrNoDeadLetterStore
+	}
+
+	logx.Infof("go-zero-utils: park dead job %s", job.Jid)
+	return d.store.Add(context.Background(), job)
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/toby1991/go-zero-utils/queue"
+	"time"
+)
+
+type helper struct {
+	job    *queue.Job
+	status *queue.StatusStore
+}
+
+// ensure type compatibility
+var _ queue.Helper = &helper{}
+
+func (h *helper) Job() *queue.Job {
+	return h.job
+}
+
+func (h *helper) Jid() string {
+	return h.Job().Jid
+}
+
+func (h *helper) JobType() string {
+	return h.Job().Type
+}
+
+func (h *helper) Custom(key string) (value interface{}, ok bool) {
+	return h.Job().GetCustom(key)
+}
+
+func (h *helper) Bid() string {
+	if b, ok := h.Job().GetCustom("bid"); ok {
+		bid, _ := b.(string)
+		return bid
+	}
+	return ""
+}
+
+func (h *helper) CallbackBid() string {
+	if b, ok := h.Job().GetCustom("_bid"); ok {
+		bid, _ := b.(string)
+		return bid
+	}
+	return ""
+}
+
+func (h *helper) Batch(f func(*faktory.Batch) error) error {
+	return queue.ErrUnsupported
+}
+
+func (h *helper) With(f func(*faktory.Client) error) error {
+	return queue.ErrUnsupported
+}
+
+// TrackProgress records the progress in the status store, see memoryClient.SetStatusStore
+func (h *helper) TrackProgress(percent int, desc string, reserveUntil *time.Time) error {
+	if h.status == nil {
+		return queue.ErrUnsupported
+	}
+
+	h.status.Progress(h.job, percent, desc)
+	return nil
+}
@@ -0,0 +1,12 @@
+package memory
+
+import (
+	"github.com/toby1991/go-zero-utils/queue"
+)
+
+type MemoryClient interface {
+	queue.Client
+
+	// Pending returns the number of jobs waiting in topic, dead jobs wait in "<queue>-dlq".
+	Pending(topic string) int
+}
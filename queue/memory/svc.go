@@ -0,0 +1,338 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/toby1991/go-zero-utils/queue"
+	"github.com/zeromicro/go-zero/core/logx"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrStopped = errors.New("memory: client is stopped")
+
+// ensure type compatibility
+var (
+	_ MemoryClient        = &memoryClient{}
+	_ queue.BulkPusher    = &memoryClient{}
+	_ queue.DeadLetterer  = &memoryClient{}
+	_ queue.GroupPusher   = &memoryClient{}
+	_ queue.StatusTracker = &memoryClient{}
+)
+
+// memoryClient runs jobs entirely in-process, it is meant for unit tests
+// and single-process deployments, jobs are lost when the process exits.
+type memoryClient struct {
+	queue.Middlewares
+
+	_conf      MemoryConf
+	backoff    queue.Backoff
+	dlq        *dlq
+	status     *queue.StatusStore
+	workflow   *queue.Workflow
+	processors map[string]queue.ContextJobProcessor
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  map[string][]*queue.Job // topic => jobs ready to run
+	timers   map[*time.Timer]struct{}
+	stopped  bool
+	wg       sync.WaitGroup
+	inflight queue.Inflight
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewMemory(conf MemoryConf) *memoryClient {
+	c := &memoryClient{
+		_conf:      conf,
+		backoff:    queue.ExponentialBackoff(conf.RetryBackoff, conf.MaxRetryBackoff),
+		processors: make(map[string]queue.ContextJobProcessor),
+		pending:    make(map[string][]*queue.Job),
+		timers:     make(map[*time.Timer]struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.dlq = newDlq(c, conf.Dlq)
+	c.workflow = queue.NewWorkflow(c)
+	c.workflow.SetGroupStore(queue.NewMemoryGroupStore(0))
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	return c
+}
+
+func (c *memoryClient) Register(jobType string, processor queue.JobProcessor) {
+	c.RegisterContext(jobType, processor.WithContext())
+}
+func (c *memoryClient) RegisterContext(jobType string, processor queue.ContextJobProcessor) {
+	c.processors[jobType] = processor
+}
+func (c *memoryClient) Context() context.Context {
+	return c.ctx
+}
+func (c *memoryClient) Start() {
+	concurrency := c._conf.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	for i := 0; i < concurrency; i++ {
+		c.wg.Add(1)
+		go c.work(false)
+	}
+
+	// the same as nsq, dlq topics are processed with concurrency 1
+	c.wg.Add(1)
+	go c.work(true)
+}
+func (c *memoryClient) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	for timer := range c.timers {
+		timer.Stop()
+	}
+	c.timers = make(map[*time.Timer]struct{})
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	abandoned := c.inflight.Wait(c._conf.ShutdownTimeout)
+	c.cancel()
+	queue.ReportAbandoned(abandoned)
+
+	// workers exit once their job returns, abandoned jobs may ignore the cancellation
+	if len(abandoned) <= 0 {
+		c.wg.Wait()
+	}
+}
+func (c *memoryClient) Push(job *queue.Job) error {
+	return c.PushCtx(context.Background(), job)
+}
+func (c *memoryClient) PushCtx(ctx context.Context, job *queue.Job) (err error) {
+	_, span := queue.StartPushSpan(ctx, job)
+	defer func() { queue.EndSpan(span, err) }()
+
+	// Topic = job.Queue
+	// delay = job.At
+
+	delay := time.Duration(0)
+	if len(job.At) > 0 {
+		//	job.At is time.RFC3339Nano string
+		jobAt, err := time.Parse(time.RFC3339Nano, job.At)
+		if err != nil {
+			return err
+		}
+		delay = jobAt.Sub(time.Now())
+	}
+
+	// round trip through json, so processors get args exactly like a real broker delivers them
+	jobJsonBytes, err := job.JsonBytes()
+	if err != nil {
+		return err
+	}
+	var pushed queue.Job
+	if err := json.Unmarshal(jobJsonBytes, &pushed); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	stopped := c.stopped
+	c.mu.Unlock()
+	if stopped {
+		return ErrStopped
+	}
+
+	pushedAt := time.Now()
+	c.schedule(pushed.Queue, &pushed, delay)
+	c.status.Enqueued(&pushed, pushedAt)
+	return nil
+}
+func (c *memoryClient) PushBulk(jobs []*queue.Job) (map[string]error, error) {
+	failed := make(map[string]error)
+	for _, job := range jobs {
+		if err := c.Push(job); err != nil {
+			failed[job.Jid] = err
+		}
+	}
+
+	if len(jobs) > 0 && len(failed) == len(jobs) {
+		for _, err := range failed {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+func (c *memoryClient) SetDeadLetterStore(store queue.DeadLetterStore) {
+	c.dlq.store = store
+}
+func (c *memoryClient) DeadLetters() *queue.DeadLetters {
+	return queue.NewDeadLetters(c.dlq.store, c)
+}
+func (c *memoryClient) SetGroupStore(store queue.GroupStore) {
+	c.workflow.SetGroupStore(store)
+}
+func (c *memoryClient) PushGroup(ctx context.Context, callback *queue.Job, members ...*queue.Job) (string, error) {
+	return c.workflow.PushGroup(ctx, callback, members...)
+}
+
+func (c *memoryClient) SetStatusStore(store *queue.StatusStore) {
+	c.status = store
+}
+func (c *memoryClient) Pending(topic string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.pending[topic])
+}
+
+// schedule enqueues job into topic once delay has elapsed
+func (c *memoryClient) schedule(topic string, job *queue.Job, delay time.Duration) {
+	if delay <= 0 {
+		c.enqueue(topic, job)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		c.mu.Lock()
+		delete(c.timers, timer)
+		c.mu.Unlock()
+
+		c.enqueue(topic, job)
+	})
+	c.timers[timer] = struct{}{}
+}
+
+func (c *memoryClient) enqueue(topic string, job *queue.Job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	job.EnqueuedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	c.pending[topic] = append(c.pending[topic], job)
+	c.cond.Broadcast()
+}
+
+// dequeue blocks until a job is ready in a (dlq) topic, it returns false once the client is stopped
+func (c *memoryClient) dequeue(dlq bool) (topic string, job *queue.Job, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.stopped {
+			return "", nil, false
+		}
+
+		for topic, jobs := range c.pending {
+			if len(jobs) <= 0 || strings.HasSuffix(topic, TOPIC_DLQ_SUFFIX) != dlq {
+				continue
+			}
+
+			job, c.pending[topic] = jobs[0], jobs[1:]
+			return topic, job, true
+		}
+
+		c.cond.Wait()
+	}
+}
+
+func (c *memoryClient) work(dlq bool) {
+	defer c.wg.Done()
+
+	for {
+		topic, job, ok := c.dequeue(dlq)
+		if !ok {
+			return
+		}
+
+		c.process(topic, job)
+	}
+}
+
+func (c *memoryClient) process(topic string, job *queue.Job) {
+	c.inflight.Add(job)
+	defer c.inflight.Done(job)
+
+	// jobs reprocessed from the dlq were followed up when they died
+	reprocessing := strings.HasSuffix(topic, TOPIC_DLQ_SUFFIX)
+
+	if job.Expired() {
+		logx.Infof("go-zero-utils: discard expired job %s", job.Jid)
+		c.status.Failed(job, nil, true)
+		if !reprocessing {
+			c.workflow.Dead(context.Background(), job)
+		}
+		return
+	}
+
+	c.status.Running(job)
+	err := c.perform(job)
+	if err == nil {
+		c.status.Succeeded(job)
+		if !reprocessing {
+			c.workflow.Succeeded(context.Background(), job)
+		}
+		return
+	}
+
+	// over a limit, it runs again later untouched, this is not a failure
+	if delay, ok := queue.SnoozeDelay(err); ok {
+		c.status.Snoozed(job)
+		c.schedule(topic, job, delay)
+		return
+	}
+
+	logx.Errorf("go-zero-utils: error running %s job %s: %v", job.Type, job.Jid, err)
+
+	retry, delay := job.Fail(err, c.backoff)
+
+	// reprocessing failed, park the job, it keeps failing in the dlq slowly if it can not be parked
+	if reprocessing {
+		if parkErr := c.dlq.Park(job); parkErr != nil {
+			logx.Error("dlq error: ", parkErr)
+			c.status.Failed(job, err, false)
+			c.schedule(topic, job, c.backoff(job.Failure.RetryCount))
+			return
+		}
+		c.status.Failed(job, err, true)
+		return
+	}
+
+	c.status.Failed(job, err, !retry)
+	if retry {
+		c.schedule(topic, job, delay)
+		return
+	}
+
+	if job.Discardable(err) {
+		logx.Infof("go-zero-utils: discard failed job %s", job.Jid)
+	} else if err := c.dlq.RequeueDeadJob(job); err != nil {
+		logx.Error("dlq error: ", err)
+	}
+	c.workflow.Dead(context.Background(), job)
+}
+
+func (c *memoryClient) perform(job *queue.Job) error {
+	processor, ok := c.processors[job.Type]
+	if !ok {
+		return fmt.Errorf("no processor registered for job type %s", job.Type)
+	}
+
+	help := &helper{job: job, status: c.status}
+	ctx, span := queue.StartJobSpan(c.ctx, job)
+	ctx, cancel := queue.JobContext(ctx, help, job.ReserveFor)
+	defer cancel()
+
+	err := c.Then(job.Type, processor)(ctx, help, job.Args...)
+	queue.EndSpan(span, err)
+	return err
+}
@@ -0,0 +1,487 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/toby1991/go-zero-utils/bizmemory"
+	"github.com/toby1991/go-zero-utils/queue"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type DelayGoodsKlineDataFillingJobData struct {
+	GoodsId   uint64 `json:"goodsId"`   // 商品id
+	SiteId    uint64 `json:"siteId"`    // 站点id
+	KlineType string `json:"klineType"` // 5m, 15m, 30m, 1h
+}
+
+func Test_memoryClient_Push(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      *queue.Job
+		failures int // processor fails this many times before succeeding
+		wantRuns int
+		wantDlq  bool
+	}{
+		{
+			name:     "success",
+			job:      queue.NewJob("kline_filling", &DelayGoodsKlineDataFillingJobData{GoodsId: 1, SiteId: 1, KlineType: "5m"}),
+			wantRuns: 1,
+		},
+		{
+			name: "delayed",
+			job: func() *queue.Job {
+				j := queue.NewJob("kline_filling", &DelayGoodsKlineDataFillingJobData{GoodsId: 1, SiteId: 1, KlineType: "5m"})
+				j.At = time.Now().Add(50 * time.Millisecond).Format(time.RFC3339Nano)
+				return j
+			}(),
+			wantRuns: 1,
+		},
+		{
+			name: "retry then success",
+			job: func() *queue.Job {
+				retry := 2
+				j := queue.NewJob("kline_filling", &DelayGoodsKlineDataFillingJobData{GoodsId: 1, SiteId: 1, KlineType: "5m"})
+				j.Retry = &retry
+				return j
+			}(),
+			failures: 2,
+			wantRuns: 3,
+		},
+		{
+			name: "retry exhausted to dlq",
+			job: func() *queue.Job {
+				retry := 1
+				j := queue.NewJob("kline_filling", &DelayGoodsKlineDataFillingJobData{GoodsId: 1, SiteId: 1, KlineType: "5m"})
+				j.Retry = &retry
+				return j
+			}(),
+			failures: 2,
+			wantRuns: 3, // 1 run + 1 retry + 1 run in dlq
+			wantDlq:  true,
+		},
+		{
+			name: "direct to morgue",
+			job: func() *queue.Job {
+				j := queue.NewJob("kline_filling", &DelayGoodsKlineDataFillingJobData{GoodsId: 1, SiteId: 1, KlineType: "5m"})
+				j.Retry = &queue.RetryPolicyDirectToMorgue
+				return j
+			}(),
+			failures: 1,
+			wantRuns: 2,
+			wantDlq:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewMemory(MemoryConf{Concurrency: 2})
+
+			type run struct {
+				helper queue.Helper
+				args   []interface{}
+			}
+			runs := make(chan run, 10)
+			failures := tt.failures
+			c.Register("kline_filling", func(helper queue.Helper, args ...interface{}) error {
+				runs <- run{helper: helper, args: args}
+				if failures > 0 {
+					failures--
+					return errors.New("test error")
+				}
+				return nil
+			})
+			c.Start()
+			defer c.Stop()
+
+			if err := c.Push(tt.job); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+
+			for i := 0; i < tt.wantRuns; i++ {
+				select {
+				case r := <-runs:
+					if r.helper.Jid() != tt.job.Jid || r.helper.JobType() != "kline_filling" {
+						t.Errorf("helper = %s %s, want %s kline_filling", r.helper.Jid(), r.helper.JobType(), tt.job.Jid)
+					}
+					data, ok := r.args[0].(map[string]interface{})
+					if !ok || data["klineType"] != "5m" {
+						t.Errorf("args = %v", r.args)
+					}
+					if tt.wantDlq && i == tt.wantRuns-1 {
+						failure := r.helper.(*helper).Job().Failure
+						if failure == nil || failure.ErrorMessage != "test error" {
+							t.Errorf("dlq job failure = %+v", failure)
+						}
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("got %d runs, want %d", i, tt.wantRuns)
+				}
+			}
+
+			select {
+			case <-runs:
+				t.Errorf("got more than %d runs", tt.wantRuns)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func (d *DelayGoodsKlineDataFillingJobData) Validate() error {
+	if d.GoodsId == 0 {
+		return errors.New("goodsId is required")
+	}
+	return nil
+}
+
+func Test_memoryClient_TypedJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload interface{}
+		wantDlq bool
+	}{
+		{
+			name:    "decoded",
+			payload: &DelayGoodsKlineDataFillingJobData{GoodsId: 1, SiteId: 2, KlineType: "5m"},
+		},
+		{
+			name:    "invalid",
+			payload: &DelayGoodsKlineDataFillingJobData{SiteId: 2, KlineType: "5m"},
+			wantDlq: true,
+		},
+		{
+			name:    "undecodable",
+			payload: "not an object",
+			wantDlq: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewMemory(MemoryConf{Concurrency: 1})
+
+			payloads := make(chan DelayGoodsKlineDataFillingJobData, 1)
+			queue.Register(c, "kline_filling", func(ctx context.Context, helper queue.Helper, payload DelayGoodsKlineDataFillingJobData) error {
+				if queue.HelperFor(ctx) != helper {
+					t.Errorf("HelperFor(ctx) != helper")
+				}
+				payloads <- payload
+				return nil
+			})
+			errs := make(chan error, 1)
+			c.Use(func(next queue.JobProcessor) queue.JobProcessor {
+				return func(helper queue.Helper, args ...interface{}) error {
+					err := next(helper, args...)
+					select {
+					case errs <- err:
+					default:
+					}
+					return err
+				}
+			})
+			c.Start()
+			defer c.Stop()
+
+			job, err := queue.NewTypedJob("kline_filling", tt.payload)
+			if err != nil {
+				t.Fatalf("NewTypedJob() error = %v", err)
+			}
+			if err := c.Push(job); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+
+			if tt.wantDlq {
+				select {
+				case err := <-errs:
+					if !queue.IsDead(err) {
+						t.Errorf("error = %v, want a dead error", err)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("processor not called")
+				}
+				select {
+				case payload := <-payloads:
+					t.Errorf("processor called with %+v", payload)
+				default:
+				}
+				return
+			}
+
+			select {
+			case payload := <-payloads:
+				if payload != *tt.payload.(*DelayGoodsKlineDataFillingJobData) {
+					t.Errorf("payload = %+v, want %+v", payload, tt.payload)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("processor not called")
+			}
+		})
+	}
+}
+
+func Test_memoryClient_DeadLetters(t *testing.T) {
+	tests := []struct {
+		name string
+		conf queue.DlqConf
+		runs int // runs before the job is parked
+	}{
+		{name: "park", conf: queue.DlqConf{Mode: queue.DlqPark}, runs: 1},
+		{name: "park by queue", conf: queue.DlqConf{Queues: map[string]string{"default": queue.DlqPark}}, runs: 1},
+		{name: "reprocess then park", conf: queue.DlqConf{Mode: queue.DlqReprocess}, runs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewMemory(MemoryConf{Concurrency: 1, Dlq: tt.conf})
+
+			runs := make(chan struct{}, 10)
+			var fail atomic.Bool
+			fail.Store(true)
+			c.Register("kline_filling", func(helper queue.Helper, args ...interface{}) error {
+				runs <- struct{}{}
+				if fail.Load() {
+					return errors.New("test error")
+				}
+				return nil
+			})
+			c.Start()
+			defer c.Stop()
+
+			job := queue.NewJob("kline_filling", &DelayGoodsKlineDataFillingJobData{GoodsId: 1, SiteId: 1, KlineType: "5m"})
+			job.Retry = &queue.RetryPolicyDirectToMorgue
+			if err := c.Push(job); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+			for i := 0; i < tt.runs; i++ {
+				select {
+				case <-runs:
+				case <-time.After(time.Second):
+					t.Fatalf("got %d runs, want %d", i, tt.runs)
+				}
+			}
+
+			ctx := context.Background()
+			deadLetters := c.DeadLetters()
+			var dead []*queue.Job
+			for start := time.Now(); len(dead) <= 0 && time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+				dead, _ = deadLetters.List(ctx, "default", 0, 10)
+			}
+			if len(dead) != 1 || dead[0].Jid != job.Jid || dead[0].Failure == nil || dead[0].Failure.ErrorMessage != "test error" {
+				t.Fatalf("List() = %+v, want the failed job", dead)
+			}
+
+			// replayed with a clean failure, the job runs once more
+			fail.Store(false)
+			if err := deadLetters.Replay(ctx, job.Jid); err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			select {
+			case <-runs:
+			case <-time.After(time.Second):
+				t.Fatal("replayed job not run")
+			}
+			if _, err := deadLetters.Get(ctx, job.Jid); err != queue.ErrDeadJobNotFound {
+				t.Errorf("Get() error = %v, want %v", err, queue.ErrDeadJobNotFound)
+			}
+
+			select {
+			case <-runs:
+				t.Errorf("got more than %d runs", tt.runs+1)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func Test_memoryClient_Status(t *testing.T) {
+	tests := []struct {
+		name         string
+		retry        int
+		failures     int
+		wantStatus   string
+		wantAttempts int
+		wantError    string
+	}{
+		{name: "succeeded", retry: 1, wantStatus: queue.StatusSucceeded, wantAttempts: 1},
+		{name: "retry then succeeded", retry: 1, failures: 1, wantStatus: queue.StatusSucceeded, wantAttempts: 2, wantError: "test error"},
+		{name: "dead", retry: queue.RetryPolicyEmphemeral, failures: 1, wantStatus: queue.StatusDead, wantAttempts: 1, wantError: "test error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewMemory(MemoryConf{Concurrency: 1, RetryBackoff: time.Millisecond, MaxRetryBackoff: time.Millisecond})
+			status := queue.NewStatusStore(bizmemory.NewMemory(bizmemory.BizMemoryConf{DefaultExpirationMinute: 1, CleanUpIntervalMinute: 1}), 0)
+			c.SetStatusStore(status)
+
+			failures := tt.failures
+			c.Register("kline_filling", func(helper queue.Helper, args ...interface{}) error {
+				if err := helper.TrackProgress(50, "half way", nil); err != nil {
+					t.Errorf("TrackProgress() error = %v", err)
+				}
+				if failures > 0 {
+					failures--
+					return errors.New("test error")
+				}
+				return nil
+			})
+			c.Start()
+			defer c.Stop()
+
+			job := queue.NewJob("kline_filling", 1)
+			job.Retry = &tt.retry
+			if err := c.Push(job); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+
+			var got *queue.JobStatus
+			for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+				if got, _ = status.Get(job.Jid); got != nil && got.Finished() {
+					break
+				}
+			}
+			if got == nil || got.Status != tt.wantStatus || got.Attempts != tt.wantAttempts || got.LastError != tt.wantError || got.Progress != 50 {
+				t.Errorf("Get() = %+v, want %s after %d attempts", got, tt.wantStatus, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func Test_memoryClient_PushBulk(t *testing.T) {
+	c := NewMemory(MemoryConf{Concurrency: 2})
+
+	runs := make(chan string, 10)
+	c.Register("kline_filling", func(helper queue.Helper, args ...interface{}) error {
+		runs <- helper.Jid()
+		return nil
+	})
+	c.Start()
+	defer c.Stop()
+
+	jobs := []*queue.Job{queue.NewJob("kline_filling", 1), queue.NewJob("kline_filling", 2), queue.NewJob("kline_filling", 3)}
+	jobs[1].At = time.Now().Add(50 * time.Millisecond).Format(time.RFC3339Nano)
+	jobs[2].At = "not a time"
+
+	failed, err := c.PushBulk(jobs)
+	if err != nil {
+		t.Fatalf("PushBulk() error = %v", err)
+	}
+	if len(failed) != 1 || failed[jobs[2].Jid] == nil {
+		t.Fatalf("PushBulk() failed = %v, want %s only", failed, jobs[2].Jid)
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case jid := <-runs:
+			got[jid] = true
+		case <-time.After(time.Second):
+			t.Fatalf("got %d runs, want 2", i)
+		}
+	}
+	if !got[jobs[0].Jid] || !got[jobs[1].Jid] {
+		t.Errorf("runs = %v, want %s and %s", got, jobs[0].Jid, jobs[1].Jid)
+	}
+}
+
+func Test_memoryClient_Snooze(t *testing.T) {
+	c := NewMemory(MemoryConf{Concurrency: 1})
+
+	runs := make(chan *queue.Job, 10)
+	snoozed := false
+	c.Register("kline_filling", func(h queue.Helper, args ...interface{}) error {
+		runs <- h.(*helper).Job()
+		if !snoozed {
+			snoozed = true
+			return queue.Snooze(50 * time.Millisecond)
+		}
+		return nil
+	})
+	c.Start()
+	defer c.Stop()
+
+	if err := c.Push(queue.NewJob("kline_filling", 1)); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case job := <-runs:
+			if job.Failure != nil {
+				t.Errorf("run %d: snoozed job failure = %+v, want none", i, job.Failure)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %d runs, want 2", i)
+		}
+	}
+}
+
+func Test_memoryClient_Workflow(t *testing.T) {
+	// dead jobs are parked, not reprocessed
+	c := NewMemory(MemoryConf{Concurrency: 2, Dlq: queue.DlqConf{Mode: queue.DlqPark}})
+
+	runs := make(chan *queue.Job, 10)
+	for _, jobType := range []string{"a", "b", "compensate", "callback"} {
+		c.Register(jobType, func(h queue.Helper, args ...interface{}) error {
+			runs <- h.(*helper).Job()
+			if len(args) > 0 && args[0] == "fail" {
+				return queue.Dead(errors.New("test error"))
+			}
+			return nil
+		})
+	}
+	c.Start()
+	defer c.Stop()
+
+	// waits for the runs of the given job types, in any order
+	wait := func(jobTypes ...string) map[string]*queue.Job {
+		got := make(map[string]*queue.Job)
+		for range jobTypes {
+			select {
+			case job := <-runs:
+				got[job.Type] = job
+			case <-time.After(time.Second):
+				t.Fatalf("got runs %v, want %v", got, jobTypes)
+			}
+		}
+		for _, jobType := range jobTypes {
+			if _, ok := got[jobType]; !ok {
+				t.Fatalf("got runs %v, want %v", got, jobTypes)
+			}
+		}
+		return got
+	}
+
+	t.Run("on success", func(t *testing.T) {
+		a := queue.NewJob("a").OnSuccess(queue.NewJob("b")).OnFailure(queue.NewJob("compensate"))
+		if err := c.Push(a); err != nil {
+			t.Fatal(err)
+		}
+		wait("a", "b")
+	})
+
+	t.Run("on failure", func(t *testing.T) {
+		a := queue.NewJob("a", "fail").OnSuccess(queue.NewJob("b")).OnFailure(queue.NewJob("compensate"))
+		if err := c.Push(a); err != nil {
+			t.Fatal(err)
+		}
+		got := wait("a", "compensate")
+		if failedJid, _ := got["compensate"].GetCustom("failed_jid"); failedJid != a.Jid {
+			t.Errorf("failed_jid = %v, want %s", failedJid, a.Jid)
+		}
+	})
+
+	t.Run("group", func(t *testing.T) {
+		gid, err := c.PushGroup(context.Background(), queue.NewJob("callback"), queue.NewJob("a"), queue.NewJob("b"), queue.NewJob("a", "fail"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := wait("a", "b", "a", "callback")
+		callback := got["callback"]
+		if id, _ := callback.GetCustom("group_id"); id != gid {
+			t.Errorf("group_id = %v, want %s", id, gid)
+		}
+		succeeded, _ := callback.GetCustom("group_succeeded")
+		failed, _ := callback.GetCustom("group_failed")
+		if succeeded != float64(2) || failed != float64(1) {
+			t.Errorf("group succeeded %v failed %v, want 2 and 1", succeeded, failed)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"github.com/zeromicro/go-zero/core/metric"
+	"time"
+)
+
+const metricNamespace = "queue"
+
+// the labels are bounded by the queues and job types of the application, never by jids or args,
+// they are only recorded when go-zero's Prometheus config is enabled
+var (
+	metricPushTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "push",
+		Name:      "total",
+		Help:      "queue jobs pushed, by result.",
+		Labels:    []string{"backend", "queue", "result"},
+	})
+
+	metricJobDur = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "jobs",
+		Name:      "duration_ms",
+		Help:      "queue jobs processing duration(ms).",
+		Labels:    []string{"backend", "queue", "jobtype", "result"},
+		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 300000},
+	})
+
+	metricJobInflight = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "jobs",
+		Name:      "inflight",
+		Help:      "queue jobs being processed.",
+		Labels:    []string{"backend", "queue", "jobtype"},
+	})
+
+	metricJobRetryTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "jobs",
+		Name:      "retry_total",
+		Help:      "queue jobs failed and scheduled for a retry.",
+		Labels:    []string{"backend", "queue", "jobtype"},
+	})
+
+	metricJobDeadTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "jobs",
+		Name:      "dead_total",
+		Help:      "queue jobs failed for good, by action: dlq, park or discard.",
+		Labels:    []string{"backend", "queue", "jobtype", "action"},
+	})
+
+	metricScheduledLag = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "jobs",
+		Name:      "scheduled_lag_ms",
+		Help:      "delay(ms) between the At of a scheduled job and its start.",
+		Labels:    []string{"backend", "queue"},
+		Buckets:   []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 300000},
+	})
+)
+
+const (
+	DeadActionDlq     = "dlq"     // moved to the dlq queue / topic
+	DeadActionPark    = "park"    // parked in the DeadLetterStore
+	DeadActionDiscard = "discard" // dropped, see RetryPolicyEmphemeral
+)
+
+// Metrics records the queue metrics of a backend, the clients call it through the job lifecycle
+type Metrics struct {
+	backend string
+}
+
+func NewMetrics(backend string) *Metrics {
+	return &Metrics{backend: backend}
+}
+
+// Push is called once a job is pushed, err is the result
+func (m *Metrics) Push(queue string, err error) {
+	metricPushTotal.Inc(m.backend, queue, result(err))
+}
+
+// Started is called when an attempt starts, it returns the start time for Done
+func (m *Metrics) Started(job *Job) time.Time {
+	now := time.Now()
+	metricJobInflight.Inc(m.backend, job.Queue, job.Type)
+
+	// the first attempt of a scheduled job, retries are late on purpose
+	if len(job.At) > 0 && job.Failure == nil {
+		if at, err := time.Parse(time.RFC3339Nano, job.At); err == nil && now.After(at) {
+			metricScheduledLag.Observe(now.Sub(at).Milliseconds(), m.backend, job.Queue)
+		}
+	}
+
+	return now
+}
+
+// Done is called when an attempt started at start returns err
+func (m *Metrics) Done(job *Job, start time.Time, err error) {
+	metricJobInflight.Dec(m.backend, job.Queue, job.Type)
+	metricJobDur.Observe(time.Since(start).Milliseconds(), m.backend, job.Queue, job.Type, result(err))
+}
+
+// Retried is called when a failed job is scheduled for a retry
+func (m *Metrics) Retried(job *Job) {
+	metricJobRetryTotal.Inc(m.backend, job.Queue, job.Type)
+}
+
+// Dead is called when a job failed for good, action is one of DeadActionDlq, DeadActionPark, DeadActionDiscard
+func (m *Metrics) Dead(job *Job, action string) {
+	metricJobDeadTotal.Inc(m.backend, job.Queue, job.Type, action)
+}
+
+func result(err error) string {
+	if _, ok := SnoozeDelay(err); ok {
+		return "snoozed"
+	}
+	if err != nil {
+		return "fail"
+	}
+	return "ok"
+}
@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zeromicro/go-zero/core/logx"
+	"runtime/debug"
+	"time"
+)
+
+// ErrJobTimeout is returned by the Timeout middleware.
+var ErrJobTimeout = errors.New("go-zero-utils: job timeout")
+
+// Middleware wraps a JobProcessor, to run code around every job it processes.
+type Middleware func(next JobProcessor) JobProcessor
+
+// Chain wraps processor with middlewares, the first middleware is the outermost one.
+func Chain(processor JobProcessor, middlewares ...Middleware) JobProcessor {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		processor = middlewares[i](processor)
+	}
+	return processor
+}
+
+// Middlewares keeps the global and per job type middlewares of a queue client,
+// it is embedded by the clients. Middlewares must be registered before Start.
+type Middlewares struct {
+	global    []Middleware
+	byJobType map[string][]Middleware
+}
+
+// Use registers middlewares for every job type.
+func (m *Middlewares) Use(middlewares ...Middleware) {
+	m.global = append(m.global, middlewares...)
+}
+
+// UseFirst registers middlewares for every job type, ahead of the ones already registered,
+// e.g. ahead of the Logging registered by the clients.
+func (m *Middlewares) UseFirst(middlewares ...Middleware) {
+	m.global = append(append([]Middleware(nil), middlewares...), m.global...)
+}
+
+// UseFor registers middlewares for jobType only, they run inside the global ones.
+func (m *Middlewares) UseFor(jobType string, middlewares ...Middleware) {
+	if m.byJobType == nil {
+		m.byJobType = make(map[string][]Middleware)
+	}
+	m.byJobType[jobType] = append(m.byJobType[jobType], middlewares...)
+}
+
+// Then wraps the processor of jobType with the registered middlewares,
+// the context is passed through the chain untouched.
+func (m *Middlewares) Then(jobType string, processor ContextJobProcessor) ContextJobProcessor {
+	middlewares := make([]Middleware, 0, len(m.global)+len(m.byJobType[jobType]))
+	middlewares = append(middlewares, m.global...)
+	middlewares = append(middlewares, m.byJobType[jobType]...)
+
+	return func(ctx context.Context, helper Helper, args ...interface{}) error {
+		return Chain(func(helper Helper, args ...interface{}) error {
+			// apply the contexts derived by the middlewares, e.g. Timeout, the processor gets the helper of the client
+			for h, ok := helper.(*contextHelper); ok; h, ok = helper.(*contextHelper) {
+				var cancel context.CancelFunc
+				ctx, cancel = h.derive(ctx)
+				defer cancel()
+				helper = h.Helper
+			}
+			return processor(ctx, helper, args...)
+		}, middlewares...)(helper, args...)
+	}
+}
+
+// contextHelper passes a context derivation down the chain, a middleware can not reach the context itself.
+type contextHelper struct {
+	Helper
+	derive func(ctx context.Context) (context.Context, context.CancelFunc)
+}
+
+// Recover turns a panic in the processor into an error, so the job is retried instead of crashing the worker.
+func Recover() Middleware {
+	return func(next JobProcessor) JobProcessor {
+		return func(helper Helper, args ...interface{}) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					logx.Errorw("job panic",
+						logx.Field("jid", helper.Jid()),
+						logx.Field("jobtype", helper.JobType()),
+						logx.Field("panic", fmt.Sprint(p)),
+						logx.Field("stack", string(debug.Stack())),
+					)
+					err = fmt.Errorf("go-zero-utils: job panic: %v", p)
+				}
+			}()
+
+			return next(helper, args...)
+		}
+	}
+}
+
+// Logging logs the start and the result of every job with its jid and jobtype.
+func Logging() Middleware {
+	return func(next JobProcessor) JobProcessor {
+		return func(helper Helper, args ...interface{}) error {
+			fields := []logx.LogField{
+				logx.Field("jid", helper.Jid()),
+				logx.Field("jobtype", helper.JobType()),
+			}
+			logx.Infow("Working on job", fields...)
+
+			start := time.Now()
+			err := next(helper, args...)
+
+			fields = append(fields, logx.Field("duration", time.Since(start).String()))
+			if err != nil {
+				logx.Errorw("Job failed", append(fields, logx.Field("error", err.Error()))...)
+			} else {
+				logx.Infow("Job done", fields...)
+			}
+			return err
+		}
+	}
+}
+
+// Duration reports how long every job takes, e.g. to feed a histogram.
+func Duration(report func(helper Helper, duration time.Duration, err error)) Middleware {
+	return func(next JobProcessor) JobProcessor {
+		return func(helper Helper, args ...interface{}) error {
+			start := time.Now()
+			err := next(helper, args...)
+			report(helper, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Timeout cancels the context of the job after timeout, and fails it with ErrJobTimeout once the processor returned:
+// the retry never runs concurrently with the timed out attempt.
+//
+// The processor must observe its context, a JobProcessor without context is not interrupted.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next JobProcessor) JobProcessor {
+		return func(helper Helper, args ...interface{}) error {
+			var jobCtx context.Context
+			err := next(&contextHelper{Helper: helper, derive: func(ctx context.Context) (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				jobCtx = ctx
+				return ctx, cancel
+			}}, args...)
+
+			if err != nil && jobCtx != nil && errors.Is(jobCtx.Err(), context.DeadlineExceeded) {
+				return ErrJobTimeout
+			}
+			return err
+		}
+	}
+}
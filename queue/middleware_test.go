@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testHelper struct {
+	Helper
+	jid     string
+	jobType string
+	custom  map[string]interface{}
+}
+
+func (h *testHelper) Jid() string     { return h.jid }
+func (h *testHelper) JobType() string { return h.jobType }
+func (h *testHelper) Custom(key string) (interface{}, bool) {
+	val, ok := h.custom[key]
+	return val, ok
+}
+
+func TestMiddlewares_Then(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next JobProcessor) JobProcessor {
+			return func(helper Helper, args ...interface{}) error {
+				calls = append(calls, name)
+				return next(helper, args...)
+			}
+		}
+	}
+
+	var m Middlewares
+	m.Use(record("global1"), record("global2"))
+	m.UseFirst(record("first"))
+	m.UseFor("a", record("a"))
+	m.UseFor("b", record("b"))
+
+	processor := m.Then("a", JobProcessor(func(helper Helper, args ...interface{}) error {
+		calls = append(calls, "processor")
+		return nil
+	}).WithContext())
+	if err := processor(context.Background(), &testHelper{jid: "1", jobType: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"first", "global1", "global2", "a", "processor"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestBuiltinMiddlewares(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware Middleware
+		processor  ContextJobProcessor
+		wantErr    error
+	}{
+		{
+			name:       "recover",
+			middleware: Recover(),
+			processor: func(ctx context.Context, helper Helper, args ...interface{}) error {
+				panic("boom")
+			},
+			wantErr: errors.New("go-zero-utils: job panic: boom"),
+		},
+		{
+			name:       "timeout",
+			middleware: Timeout(10 * time.Millisecond),
+			processor: func(ctx context.Context, helper Helper, args ...interface{}) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantErr: ErrJobTimeout,
+		},
+		{
+			name:       "in time",
+			middleware: Timeout(100 * time.Millisecond),
+			processor: func(ctx context.Context, helper Helper, args ...interface{}) error {
+				if _, ok := helper.(*testHelper); !ok {
+					return errors.New("the processor must get the helper of the client")
+				}
+				return nil
+			},
+		},
+		{
+			name:       "logging",
+			middleware: Logging(),
+			processor: func(ctx context.Context, helper Helper, args ...interface{}) error {
+				return ErrUnsupported
+			},
+			wantErr: ErrUnsupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Middlewares
+			m.Use(tt.middleware)
+			err := m.Then("a", tt.processor)(context.Background(), &testHelper{jid: "1", jobType: "a"})
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package queue
+
+import (
+	"context"
+	red "github.com/go-redis/redis/v8"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/zeromicro/go-zero/core/logx"
+	"hash/fnv"
+	"time"
+)
+
+const (
+	customOrderingKey = "ordering_key"
+
+	orderingLockKeyPrefix = "queue:order:" // plus <key>:waiting, the jobs waiting for the key by first attempt, and <key>:seen, their last attempt
+	orderingPollInterval  = 100 * time.Millisecond
+)
+
+var (
+	// orderingAcquireScript takes the lock of a key for a job, unless an earlier job of the key waits for it, e.g. snoozed.
+	// The waiting jobs are ranked by their first attempt, those not seen for the yield are dropped as lost.
+	//
+	// KEYS[1] lock, KEYS[2] waiting, KEYS[3] seen, ARGV[1] lock id, ARGV[2] lease ms, ARGV[3] jid, ARGV[4] now ms, ARGV[5] yield ms
+	orderingAcquireScript = bizredis.NewScript(`redis.call("ZADD", KEYS[2], "NX", ARGV[4], ARGV[3])
+redis.call("HSET", KEYS[3], ARGV[3], ARGV[4])
+while true do
+    local head = redis.call("ZRANGE", KEYS[2], 0, 0)[1]
+    if head == ARGV[3] then
+        break
+    end
+    if tonumber(redis.call("HGET", KEYS[3], head) or "0") >= tonumber(ARGV[4]) - tonumber(ARGV[5]) then
+        redis.call("PEXPIRE", KEYS[2], ARGV[2])
+        redis.call("PEXPIRE", KEYS[3], ARGV[2])
+        return 0
+    end
+    redis.call("ZREM", KEYS[2], head)
+    redis.call("HDEL", KEYS[3], head)
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+    redis.call("ZREM", KEYS[2], ARGV[3])
+    redis.call("HDEL", KEYS[3], ARGV[3])
+    return 1
+end
+redis.call("PEXPIRE", KEYS[2], ARGV[2])
+redis.call("PEXPIRE", KEYS[3], ARGV[2])
+return 0`)
+
+	orderingReleaseScript = bizredis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// OrderingConf configures the Sequencer, the jobs of the same ordering key run one at a time
+type OrderingConf struct {
+	Enabled    bool          `json:",optional"`    // the clients only build a Sequencer if enabled
+	Partitions int           `json:",default=64"`  // local partitions, the keys of a partition run one at a time
+	Wait       time.Duration `json:",default=5s"`  // how long a job waits for the key held by another replica before it is snoozed
+	Lease      time.Duration `json:",default=30m"` // the key of a replica which died is freed after the lease
+	Snooze     time.Duration `json:",default=1s"`  // how long a job which could not get its key is delayed, with up to 10% jitter
+	Yield      time.Duration `json:",default=1m"`  // how long the later jobs of a key wait for a snoozed one to come back, before it is deemed lost
+}
+
+// SetOrderingKey makes j run after the jobs of key consumed before it, and never at the same time as them,
+// see Sequencer. Jobs without a key, or of different keys, run in parallel.
+func (j *Job) SetOrderingKey(key string) *Job {
+	return j.SetCustom(customOrderingKey, key)
+}
+
+// OrderingKey returns the key set by SetOrderingKey, "" if none
+func (j *Job) OrderingKey() string {
+	return orderingKey(j.GetCustom(customOrderingKey))
+}
+
+func orderingKey(val interface{}, ok bool) string {
+	if !ok {
+		return ""
+	}
+	key, _ := val.(string)
+	return key
+}
+
+// Sequencer serializes the jobs by ordering key: a key hashes to one of Partitions local locks, so the jobs
+// of a key consumed by this process run one at a time, in the order they got the lock. The redis lock
+// queue:order:<key> keeps the other replicas out, a job which can not get it within Wait is snoozed,
+// the later jobs of its key yield to it until it comes back, or for Yield at most.
+type Sequencer struct {
+	conf       OrderingConf
+	redis      bizredis.RedisScripter // nil for a single process
+	partitions []chan struct{}
+}
+
+func NewSequencer(conf OrderingConf, redis bizredis.RedisScripter) *Sequencer {
+	if conf.Partitions <= 0 {
+		conf.Partitions = 64
+	}
+	if conf.Lease <= 0 {
+		conf.Lease = DefaultReserveFor * time.Second
+	}
+	if conf.Yield <= 0 {
+		conf.Yield = time.Minute
+	}
+
+	partitions := make([]chan struct{}, conf.Partitions)
+	for i := range partitions {
+		partitions[i] = make(chan struct{}, 1)
+	}
+	return &Sequencer{conf: conf, redis: redis, partitions: partitions}
+}
+
+// Lock waits for the local partition of key, until ctx is done
+func (s *Sequencer) Lock(ctx context.Context, key string) (unlock func(), err error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	partition := s.partitions[h.Sum32()%uint32(len(s.partitions))]
+
+	select {
+	case partition <- struct{}{}:
+		return func() { <-partition }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Acquire takes the redis lock of key for the job jid, it returns a Snooze error if another replica holds it
+// for longer than Wait, or if an earlier job of the key, snoozed, has not come back yet
+func (s *Sequencer) Acquire(ctx context.Context, key string, jid string) (release func(), err error) {
+	if s.redis == nil {
+		return func() {}, nil
+	}
+
+	lockKey := orderingLockKeyPrefix + key
+	id := RandomJid()
+
+	deadline := time.Now().Add(s.conf.Wait)
+	for {
+		// ScriptRunCtx prefixes the keys in place, they are built for every run
+		keys := []string{lockKey, lockKey + ":waiting", lockKey + ":seen"}
+		resp, err := s.redis.ScriptRunCtx(ctx, orderingAcquireScript, keys,
+			id, s.conf.Lease.Milliseconds(), jid, time.Now().UnixMilli(), s.conf.Yield.Milliseconds())
+		if err != nil && err != red.Nil {
+			return nil, err
+		}
+		if acquired, _ := resp.(int64); acquired == 1 {
+			return func() {
+				if _, err := s.redis.ScriptRunCtx(context.Background(), orderingReleaseScript, []string{lockKey}, id); err != nil {
+					logx.Errorf("go-zero-utils: release ordering key %s: %v", key, err)
+				}
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, Snooze(jitter(s.conf.Snooze))
+		}
+
+		select {
+		case <-time.After(orderingPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Middleware runs the jobs with an ordering key through Lock and Acquire, for the clients which do not
+// integrate the Sequencer. Register it by UseFirst, ahead of the Logging registered by the clients, so the
+// snoozed jobs are not logged as failed; the waiting jobs hold their worker:
+//
+//	client.UseFirst(queue.NewSequencer(conf, redis).Middleware())
+func (s *Sequencer) Middleware() Middleware {
+	return func(next JobProcessor) JobProcessor {
+		return func(helper Helper, args ...interface{}) error {
+			key := orderingKey(helper.Custom(customOrderingKey))
+			if len(key) <= 0 {
+				return next(helper, args...)
+			}
+
+			ctx := context.Background()
+			unlock, err := s.Lock(ctx, key)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			release, err := s.Acquire(ctx, key, helper.Jid())
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			return next(helper, args...)
+		}
+	}
+}
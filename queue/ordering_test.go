@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// busyScripter answers the lock script as if another replica held the key
+type busyScripter struct {
+	bizredis.RedisScripter
+}
+
+func (s *busyScripter) ScriptRunCtx(ctx context.Context, script *bizredis.Script, keys []string, args ...any) (any, error) {
+	return nil, nil
+}
+
+func TestSequencer_Middleware(t *testing.T) {
+	s := NewSequencer(OrderingConf{Partitions: 4}, nil)
+
+	var running, maxRunning, ran int32
+	processor := s.Middleware()(func(helper Helper, args ...interface{}) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = processor(&testHelper{jid: RandomJid(), jobType: "goods_update", custom: map[string]interface{}{customOrderingKey: "goods:1"}})
+		}()
+	}
+	wg.Wait()
+
+	if ran != 10 {
+		t.Errorf("ran = %d, want 10", ran)
+	}
+	if maxRunning != 1 {
+		t.Errorf("max running = %d, want 1", maxRunning)
+	}
+}
+
+func TestSequencer_Acquire(t *testing.T) {
+	s := NewSequencer(OrderingConf{Snooze: time.Second}, &busyScripter{})
+
+	_, err := s.Acquire(context.Background(), "goods:1", "1")
+	delay, ok := SnoozeDelay(err)
+	if !ok || delay < time.Second {
+		t.Errorf("err = %v, want snooze 1s", err)
+	}
+
+	job := NewJob("goods_update").SetOrderingKey("goods:1")
+	if key := job.OrderingKey(); key != "goods:1" {
+		t.Errorf("OrderingKey() = %q, want goods:1", key)
+	}
+}
+
+func TestSequencer_Acquire_snoozed(t *testing.T) {
+	redis := miniredis.RunT(t)
+	port, _ := strconv.Atoi(redis.Port())
+	s := NewSequencer(OrderingConf{Snooze: time.Second, Yield: time.Minute}, bizredis.NewRedis(bizredis.BizRedisConf{Host: redis.Host(), Port: port}))
+	ctx := context.Background()
+
+	acquire := func(jid string) (func(), bool) {
+		release, err := s.Acquire(ctx, "goods:1", jid)
+		if _, snoozed := SnoozeDelay(err); err != nil && !snoozed {
+			t.Fatalf("Acquire(%s) error = %v", jid, err)
+		}
+		return release, err == nil
+	}
+
+	// another replica holds the key, the first job is snoozed
+	redis.Set(orderingLockKeyPrefix+"goods:1", "replica")
+	if _, ok := acquire("1"); ok {
+		t.Fatal("Acquire(1) got the key held by another replica")
+	}
+	redis.Del(orderingLockKeyPrefix + "goods:1")
+
+	// the key is free, yet the later job yields to the snoozed one
+	if _, ok := acquire("2"); ok {
+		t.Fatal("Acquire(2) overtook the snoozed job")
+	}
+	release, ok := acquire("1")
+	if !ok {
+		t.Fatal("Acquire(1) failed once the key was free")
+	}
+	if _, ok := acquire("2"); ok {
+		t.Fatal("Acquire(2) got the key held by 1")
+	}
+	release()
+	if _, ok := acquire("2"); !ok {
+		t.Fatal("Acquire(2) failed once 1 released the key")
+	}
+}
+
+func TestSequencer_Acquire_lost(t *testing.T) {
+	redis := miniredis.RunT(t)
+	port, _ := strconv.Atoi(redis.Port())
+	s := NewSequencer(OrderingConf{Snooze: time.Second, Yield: 10 * time.Millisecond}, bizredis.NewRedis(bizredis.BizRedisConf{Host: redis.Host(), Port: port}))
+	ctx := context.Background()
+
+	redis.Set(orderingLockKeyPrefix+"goods:1", "replica")
+	if _, err := s.Acquire(ctx, "goods:1", "1"); err == nil {
+		t.Fatal("Acquire(1) got the key held by another replica")
+	}
+	redis.Del(orderingLockKeyPrefix + "goods:1")
+
+	// the snoozed job never came back within the yield
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.Acquire(ctx, "goods:1", "2"); err != nil {
+		t.Errorf("Acquire(2) error = %v, the lost job must not block the key", err)
+	}
+}
+
+func TestSequencer_Acquire_prefixed(t *testing.T) {
+	redis := miniredis.RunT(t)
+	port, _ := strconv.Atoi(redis.Port())
+	conf := bizredis.BizRedisConf{Host: redis.Host(), Port: port, Prefix: "app:"}
+	ctx := context.Background()
+
+	// two replicas, the first one polls a few times before it gets the key
+	first := NewSequencer(OrderingConf{Wait: time.Second}, bizredis.NewRedis(conf))
+	second := NewSequencer(OrderingConf{Snooze: time.Second}, bizredis.NewRedis(conf))
+
+	redis.Set("app:"+orderingLockKeyPrefix+"goods:1", "replica")
+	go func() {
+		time.Sleep(3 * orderingPollInterval / 2)
+		redis.Del("app:" + orderingLockKeyPrefix + "goods:1")
+	}()
+	release, err := first.Acquire(ctx, "goods:1", "1")
+	if err != nil {
+		t.Fatalf("Acquire(1) error = %v", err)
+	}
+
+	if _, err := second.Acquire(ctx, "goods:1", "2"); err == nil {
+		t.Fatal("Acquire(2) got the key held by the other replica")
+	}
+	release()
+	if redis.Exists("app:" + orderingLockKeyPrefix + "goods:1") {
+		t.Error("the key is still held once released")
+	}
+	if _, err := second.Acquire(ctx, "goods:1", "2"); err != nil {
+		t.Errorf("Acquire(2) error = %v once the key was released", err)
+	}
+}
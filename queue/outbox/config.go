@@ -0,0 +1,20 @@
+package outbox
+
+import "time"
+
+type OutboxConf struct {
+	Name    string // namespaces the leader lock in redis, usually the service name
+	Dialect string `json:",default=mysql,options=mysql|sqlite3|postgres"`
+	Table   string `json:",default=outbox"` // the table of the ent schema using mixin.OutboxMixin
+
+	PollInterval time.Duration `json:",default=1s"` // how often pending rows are relayed
+	BatchSize    int           `json:",default=100"`
+	Lease        time.Duration `json:",default=30s"` // with redis, a relay which stops refreshing its lock is replaced after the lease
+
+	RetryBackoff    time.Duration `json:",default=1s"` // first retry delay of a row failing to push, doubled on every retry
+	MaxRetryBackoff time.Duration `json:",default=5m"`
+	MaxAttempts     int           `json:",default=25"` // a row failing to push as many times is dead: not relayed anymore, nor holding back its key
+
+	Retention       time.Duration `json:",default=24h"` // sent rows are deleted after
+	CleanupInterval time.Duration `json:",default=10m"`
+}
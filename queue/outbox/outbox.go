@@ -0,0 +1,194 @@
+package outbox
+
+import (
+	"context"
+	stdsql "database/sql"
+	"entgo.io/ent/dialect/sql"
+	"github.com/toby1991/go-zero-utils/queue"
+	"time"
+)
+
+const (
+	defaultDialect = "mysql"
+	defaultTable   = "outbox"
+)
+
+// Execer is the transaction the jobs are inserted with: *sql.Tx, or the Tx generated by ent
+// with the `sql/execquery` feature enabled.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (stdsql.Result, error)
+}
+
+// Message is a row of the outbox table, see mixin.OutboxMixin
+type Message struct {
+	Id          int64
+	Jid         string
+	OrderingKey string
+	Payload     []byte
+	Attempts    int
+	NextAt      time.Time
+}
+
+// Outbox inserts jobs in the transaction of the business writes, so they are pushed if and only if the transaction commits,
+// the relay pushes them afterwards.
+//
+//	tx, err := client.Tx(ctx)
+//	...
+//	if err := ob.Add(ctx, tx, queue.NewJob("kline_filling", goodsId)); err != nil {
+//	    return rollback(tx, err)
+//	}
+//	return tx.Commit()
+type Outbox struct {
+	dialect string
+	table   string
+}
+
+func NewOutbox(conf OutboxConf) *Outbox {
+	o := &Outbox{dialect: conf.Dialect, table: conf.Table}
+	if len(o.dialect) <= 0 {
+		o.dialect = defaultDialect
+	}
+	if len(o.table) <= 0 {
+		o.table = defaultTable
+	}
+	return o
+}
+
+// Add inserts job, it is pushed once tx commits, in order with the jobs of its ordering key if any, see queue.Job.SetOrderingKey
+func (o *Outbox) Add(ctx context.Context, tx Execer, job *queue.Job) error {
+	return o.AddOrdered(ctx, tx, job.OrderingKey(), job)
+}
+
+// AddOrdered inserts job with an ordering key, the jobs of a key are pushed one by one in insertion order,
+// a job failing to push holds back the next ones of its key, until it is dead after OutboxConf.MaxAttempts.
+func (o *Outbox) AddOrdered(ctx context.Context, tx Execer, key string, job *queue.Job) error {
+	// the job is pushed in the trace it was added in
+	queue.InjectTrace(ctx, job)
+
+	jobJsonBytes, err := job.JsonBytes()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	query, args := sql.Dialect(o.dialect).Insert(o.table).
+		Columns("jid", "queue", "ordering_key", "payload", "attempts", "next_at", "created_at").
+		Values(job.Jid, job.Queue, key, string(jobJsonBytes), 0, now, now).
+		Query()
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// store is the outbox table as seen by the relay
+type store interface {
+	Pending(ctx context.Context, now time.Time, limit int) ([]*Message, error)
+	Sent(ctx context.Context, id int64, at time.Time) error
+	Failed(ctx context.Context, id int64, cause error, nextAt time.Time) error
+	Dead(ctx context.Context, id int64, cause error, at time.Time) error
+	Cleanup(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ensure type compatibility
+var _ store = &sqlStore{}
+
+type sqlStore struct {
+	*Outbox
+	db *stdsql.DB
+}
+
+// Pending returns the rows due by now in insertion order, neither sent nor dead. The rows waiting for a retry
+// are left out, and so are the next ones of their ordering key, so they do not fill the batch.
+func (s *sqlStore) Pending(ctx context.Context, now time.Time, limit int) ([]*Message, error) {
+	query, args := s.pendingQuery(now, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*Message, 0)
+	for rows.Next() {
+		var message Message
+		var payload string
+		if err := rows.Scan(&message.Id, &message.Jid, &message.OrderingKey, &payload, &message.Attempts, &message.NextAt); err != nil {
+			return nil, err
+		}
+		message.Payload = []byte(payload)
+		messages = append(messages, &message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqlStore) pendingQuery(now time.Time, limit int) (string, []any) {
+	builder := sql.Dialect(s.dialect)
+	now = now.UTC()
+
+	t := builder.Table(s.table)
+	earlier := builder.Table(s.table).As("earlier")
+	waiting := builder.Select(earlier.C("id")).From(earlier).Where(sql.And(
+		sql.ColumnsEQ(earlier.C("ordering_key"), t.C("ordering_key")),
+		sql.ColumnsLT(earlier.C("id"), t.C("id")),
+		sql.IsNull(earlier.C("sent_at")),
+		sql.IsNull(earlier.C("failed_at")),
+		sql.GT(earlier.C("next_at"), now),
+	))
+
+	return builder.
+		Select(t.Columns("id", "jid", "ordering_key", "payload", "attempts", "next_at")...).
+		From(t).
+		Where(sql.And(
+			sql.IsNull(t.C("sent_at")),
+			sql.IsNull(t.C("failed_at")),
+			sql.LTE(t.C("next_at"), now),
+			sql.Or(sql.EQ(t.C("ordering_key"), ""), sql.NotExists(waiting)),
+		)).
+		OrderBy(t.C("id")).
+		Limit(limit).
+		Query()
+}
+
+func (s *sqlStore) Sent(ctx context.Context, id int64, at time.Time) error {
+	query, args := sql.Dialect(s.dialect).Update(s.table).
+		Set("sent_at", at.UTC()).
+		Add("attempts", 1).
+		Where(sql.EQ("id", id)).
+		Query()
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *sqlStore) Failed(ctx context.Context, id int64, cause error, nextAt time.Time) error {
+	query, args := sql.Dialect(s.dialect).Update(s.table).
+		Set("last_error", cause.Error()).
+		Set("next_at", nextAt.UTC()).
+		Add("attempts", 1).
+		Where(sql.EQ("id", id)).
+		Query()
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Dead gives up the row, it is kept with its last error until deleted by hand
+func (s *sqlStore) Dead(ctx context.Context, id int64, cause error, at time.Time) error {
+	query, args := sql.Dialect(s.dialect).Update(s.table).
+		Set("last_error", cause.Error()).
+		Set("failed_at", at.UTC()).
+		Add("attempts", 1).
+		Where(sql.EQ("id", id)).
+		Query()
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Cleanup deletes the rows sent before
+func (s *sqlStore) Cleanup(ctx context.Context, before time.Time) (int64, error) {
+	query, args := sql.Dialect(s.dialect).Delete(s.table).
+		Where(sql.LT("sent_at", before.UTC())).
+		Query()
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
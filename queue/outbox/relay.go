@@ -0,0 +1,213 @@
+package outbox
+
+import (
+	"context"
+	stdsql "database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/queue"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/service"
+	"sync"
+	"time"
+)
+
+const leaderKeyPrefix = "outbox:leader:"
+
+// ensure type compatibility
+var _ service.Service = &relay{}
+
+// relay pushes the rows of the outbox through a queue client. A row is pushed at least once:
+// if the process dies between the push and marking the row sent, it is pushed again.
+// With redis only the replica holding the leader lock relays, which also keeps the ordering keys in order.
+type relay struct {
+	_conf   OutboxConf
+	store   store
+	pusher  queue.Pusher
+	leader  *bizredis.RedisLock
+	backoff queue.Backoff
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRelay relays the outbox table of db through pusher, usually a queue.Client,
+// redis may be nil if a single replica runs the relay.
+func NewRelay(conf OutboxConf, db *stdsql.DB, redis bizredis.RedisClient, pusher queue.Pusher) *relay {
+	outbox := NewOutbox(conf)
+	r := newRelay(conf, &sqlStore{Outbox: outbox, db: db}, pusher)
+
+	if redis != nil {
+		r.leader = bizredis.NewRedisLock(redis, leaderKey(conf.Name, outbox.table))
+		lease := int(conf.Lease.Seconds())
+		if lease <= 0 {
+			lease = 30
+		}
+		r.leader.SetExpire(lease)
+	}
+
+	return r
+}
+
+// leaderKey namespaces the lock of table by OutboxConf.Name, the relays of different services sharing a table name
+// do not share their leader
+func leaderKey(name string, table string) string {
+	if len(name) <= 0 {
+		return leaderKeyPrefix + table
+	}
+	return leaderKeyPrefix + name + ":" + table
+}
+
+func newRelay(conf OutboxConf, store store, pusher queue.Pusher) *relay {
+	return &relay{
+		_conf:   conf,
+		store:   store,
+		pusher:  pusher,
+		backoff: queue.ExponentialBackoff(conf.RetryBackoff, conf.MaxRetryBackoff),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (r *relay) Start() {
+	pollInterval := r._conf.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	cleanupInterval := r._conf.CleanupInterval
+	if cleanupInterval <= 0 {
+		cleanupInterval = 10 * time.Minute
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		poll := time.NewTicker(pollInterval)
+		defer poll.Stop()
+		cleanup := time.NewTicker(cleanupInterval)
+		defer cleanup.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-poll.C:
+				if r.leading(context.Background()) {
+					r.relay(context.Background(), time.Now())
+				}
+			case <-cleanup.C:
+				if r.leading(context.Background()) {
+					r.cleanup(context.Background(), time.Now())
+				}
+			}
+		}
+	}()
+}
+
+func (r *relay) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+
+	if r.leader != nil {
+		if _, err := r.leader.Release(); err != nil {
+			logx.Errorf("go-zero-utils: release outbox leader: %v", err)
+		}
+	}
+}
+
+func (r *relay) leading(ctx context.Context) bool {
+	if r.leader == nil {
+		return true
+	}
+
+	// acquiring the lock we hold refreshes it
+	leading, err := r.leader.AcquireCtx(ctx)
+	return err == nil && leading
+}
+
+// relay pushes the due pending rows, a row of an ordering key is held back while an earlier one of the key is pending
+func (r *relay) relay(ctx context.Context, now time.Time) {
+	batchSize := r._conf.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	maxAttempts := r._conf.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 25
+	}
+
+	messages, err := r.store.Pending(ctx, now, batchSize)
+	if err != nil {
+		logx.Errorf("go-zero-utils: outbox pending rows: %v", err)
+		return
+	}
+
+	blocked := make(map[string]struct{})
+	for _, message := range messages {
+		if len(message.OrderingKey) > 0 {
+			if _, ok := blocked[message.OrderingKey]; ok {
+				continue
+			}
+		}
+
+		if err := r.push(message); err != nil {
+			logx.Errorf("go-zero-utils: outbox push job %s, attempt %d: %v", message.Jid, message.Attempts+1, err)
+			if message.Attempts+1 >= maxAttempts {
+				logx.Alert(fmt.Sprintf("go-zero-utils: outbox job %s is dead after %d attempts: %v", message.Jid, message.Attempts+1, err))
+				if err := r.store.Dead(ctx, message.Id, err, now); err != nil {
+					logx.Errorf("go-zero-utils: outbox job %s: %v", message.Jid, err)
+				}
+				continue
+			}
+
+			r.block(blocked, message)
+			if err := r.store.Failed(ctx, message.Id, err, now.Add(r.backoff(message.Attempts))); err != nil {
+				logx.Errorf("go-zero-utils: outbox job %s: %v", message.Jid, err)
+			}
+			continue
+		}
+
+		if err := r.store.Sent(ctx, message.Id, now); err != nil {
+			// pushed again on the next poll
+			logx.Errorf("go-zero-utils: outbox job %s: %v", message.Jid, err)
+			r.block(blocked, message)
+		}
+	}
+}
+
+func (r *relay) block(blocked map[string]struct{}, message *Message) {
+	if len(message.OrderingKey) > 0 {
+		blocked[message.OrderingKey] = struct{}{}
+	}
+}
+
+func (r *relay) push(message *Message) error {
+	var job queue.Job
+	if err := json.Unmarshal(message.Payload, &job); err != nil {
+		return err
+	}
+
+	// a duplicate, e.g. pushed by a previous attempt which failed to mark the row sent
+	if err := r.pusher.Push(&job); err != nil && !errors.Is(err, queue.ErrNotUnique) {
+		return err
+	}
+	return nil
+}
+
+func (r *relay) cleanup(ctx context.Context, now time.Time) {
+	retention := r._conf.Retention
+	if retention <= 0 {
+		retention = 24 * time.Hour
+	}
+
+	deleted, err := r.store.Cleanup(ctx, now.Add(-retention))
+	if err != nil {
+		logx.Errorf("go-zero-utils: outbox cleanup: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logx.Infof("go-zero-utils: outbox deleted %d sent rows", deleted)
+	}
+}
@@ -0,0 +1,188 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/queue"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryStore selects the pending rows like sqlStore.pendingQuery
+type memoryStore struct {
+	messages []*Message
+	sent     map[int64]bool
+	dead     map[int64]bool
+}
+
+func (s *memoryStore) Pending(ctx context.Context, now time.Time, limit int) ([]*Message, error) {
+	pending := make([]*Message, 0)
+	waiting := make(map[string]bool) // the keys of the rows waiting for a retry
+	for _, message := range s.messages {
+		if s.sent[message.Id] || s.dead[message.Id] {
+			continue
+		}
+		if message.NextAt.After(now) {
+			waiting[message.OrderingKey] = len(message.OrderingKey) > 0
+			continue
+		}
+		if !waiting[message.OrderingKey] && len(pending) < limit {
+			pending = append(pending, message)
+		}
+	}
+	return pending, nil
+}
+func (s *memoryStore) Sent(ctx context.Context, id int64, at time.Time) error {
+	s.sent[id] = true
+	return nil
+}
+func (s *memoryStore) Failed(ctx context.Context, id int64, cause error, nextAt time.Time) error {
+	for _, message := range s.messages {
+		if message.Id == id {
+			message.Attempts++
+			message.NextAt = nextAt
+		}
+	}
+	return nil
+}
+func (s *memoryStore) Dead(ctx context.Context, id int64, cause error, at time.Time) error {
+	s.dead[id] = true
+	return nil
+}
+func (s *memoryStore) Cleanup(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+type pusher struct {
+	failing map[string]bool // jid => fails
+	pushed  []string
+}
+
+func (p *pusher) Push(job *queue.Job) error {
+	if p.failing[job.Jid] {
+		return errors.New("test error")
+	}
+	p.pushed = append(p.pushed, job.Jid)
+	return nil
+}
+
+func Test_relay_relay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		keys       []string          // ordering key of the rows 1, 2, 3...
+		nextAt     map[int]time.Time // row => next attempt
+		failing    map[string]bool
+		wantPushed []string
+	}{
+		{name: "unordered", keys: []string{"", "", ""}, wantPushed: []string{"1", "2", "3"}},
+		{name: "failure does not block unordered", keys: []string{"", "", ""}, failing: map[string]bool{"1": true}, wantPushed: []string{"2", "3"}},
+		{name: "failure blocks its key", keys: []string{"a", "b", "a"}, failing: map[string]bool{"1": true}, wantPushed: []string{"2"}},
+		{name: "retry later blocks its key", keys: []string{"a", "a", "b"}, nextAt: map[int]time.Time{1: now.Add(time.Minute)}, wantPushed: []string{"3"}},
+		{name: "retry due", keys: []string{"a", "a"}, nextAt: map[int]time.Time{1: now.Add(-time.Second)}, wantPushed: []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &memoryStore{sent: make(map[int64]bool), dead: make(map[int64]bool)}
+			for i, key := range tt.keys {
+				job := queue.NewJob("kline_filling", i+1)
+				job.Jid = string(rune('1' + i))
+				payload, _ := job.JsonBytes()
+				s.messages = append(s.messages, &Message{Id: int64(i + 1), Jid: job.Jid, OrderingKey: key, Payload: payload, NextAt: tt.nextAt[i+1]})
+			}
+
+			p := &pusher{failing: tt.failing}
+			r := newRelay(OutboxConf{BatchSize: 10, RetryBackoff: time.Second, MaxRetryBackoff: time.Minute}, s, p)
+			r.relay(context.Background(), now)
+
+			if !reflect.DeepEqual(p.pushed, tt.wantPushed) && (len(p.pushed) > 0 || len(tt.wantPushed) > 0) {
+				t.Errorf("pushed %v, want %v", p.pushed, tt.wantPushed)
+			}
+			for _, message := range s.messages {
+				if tt.failing[message.Jid] && (message.Attempts != 1 || !message.NextAt.After(now)) {
+					t.Errorf("failed row %s: attempts %d, next at %s", message.Jid, message.Attempts, message.NextAt)
+				}
+			}
+		})
+	}
+}
+
+func Test_relay_poisonRow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		keys      []string // ordering key of the poison row 1 and the healthy row 2
+		wantEarly bool     // the healthy row is pushed while the poison row waits for its retry
+	}{
+		{name: "unordered", keys: []string{"", ""}, wantEarly: true},
+		{name: "other key", keys: []string{"a", "b"}, wantEarly: true},
+		{name: "same key once dead", keys: []string{"a", "a"}, wantEarly: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &memoryStore{sent: make(map[int64]bool), dead: make(map[int64]bool)}
+			for i, key := range tt.keys {
+				job := queue.NewJob("kline_filling", i+1)
+				job.Jid = string(rune('1' + i))
+				payload, _ := job.JsonBytes()
+				s.messages = append(s.messages, &Message{Id: int64(i + 1), Jid: job.Jid, OrderingKey: key, Payload: payload})
+			}
+
+			// the poison row comes first in every batch it is due in
+			p := &pusher{failing: map[string]bool{"1": true}}
+			r := newRelay(OutboxConf{BatchSize: 1, RetryBackoff: time.Second, MaxRetryBackoff: time.Second, MaxAttempts: 3}, s, p)
+			r.relay(context.Background(), now)
+			r.relay(context.Background(), now.Add(500*time.Millisecond))
+			if early := len(p.pushed) > 0; early != tt.wantEarly {
+				t.Fatalf("pushed %v while the poison row waits, want pushed %v", p.pushed, tt.wantEarly)
+			}
+
+			for i := 1; i <= 10; i++ {
+				r.relay(context.Background(), now.Add(time.Duration(i)*time.Second))
+			}
+			if !reflect.DeepEqual(p.pushed, []string{"2"}) {
+				t.Errorf("pushed %v, want [2]", p.pushed)
+			}
+			if !s.dead[1] || s.messages[0].Attempts != 2 {
+				t.Errorf("poison row dead %v after %d failed attempts, want dead after 3", s.dead[1], s.messages[0].Attempts+1)
+			}
+		})
+	}
+}
+
+func Test_sqlStore_pendingQuery(t *testing.T) {
+	s := &sqlStore{Outbox: NewOutbox(OutboxConf{})}
+	query, _ := s.pendingQuery(time.Now(), 10)
+
+	for _, want := range []string{"`outbox`.`next_at` <= ?", "`outbox`.`failed_at` IS NULL", "NOT EXISTS", "`earlier`.`next_at` > ?"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query %s, want %s", query, want)
+		}
+	}
+}
+
+func Test_relay_leader(t *testing.T) {
+	redis := miniredis.RunT(t)
+	port, _ := strconv.Atoi(redis.Port())
+	relayOf := func(name string) *relay {
+		return NewRelay(OutboxConf{Name: name, Table: "outbox"}, nil, bizredis.NewRedis(bizredis.BizRedisConf{Host: redis.Host(), Port: port}), &pusher{})
+	}
+
+	// every service leads the relay of its own outbox, even with the same table name
+	ctx := context.Background()
+	for _, r := range []*relay{relayOf("goods"), relayOf("orders")} {
+		if !r.leading(ctx) {
+			t.Errorf("%s not leading, want every service leading its relay", r._conf.Name)
+		}
+	}
+	if relayOf("goods").leading(ctx) {
+		t.Error("two replicas of a service lead")
+	}
+}
@@ -1,3 +1,17 @@
 package queue
 
+import "context"
+
 type JobProcessor func(helper Helper, args ...interface{}) error
+
+// ContextJobProcessor receives a context derived from the client's Context(), which is
+// cancelled when the client stops or when the job's reservation (ReserveFor) expires.
+// The context carries the Helper, see HelperFor.
+type ContextJobProcessor func(ctx context.Context, helper Helper, args ...interface{}) error
+
+// WithContext adapts p to ContextJobProcessor, the context is ignored.
+func (p JobProcessor) WithContext() ContextJobProcessor {
+	return func(ctx context.Context, helper Helper, args ...interface{}) error {
+		return p(helper, args...)
+	}
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"fmt"
+	mathrand "math/rand"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Backoff returns how long to wait before the retryCount-th retry (starts at 0).
+type Backoff func(retryCount int) time.Duration
+
+// ExponentialBackoff doubles the delay on every retry, starting from base and
+// capped at max (no cap if max <= 0), with up to 10% jitter.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(retryCount int) time.Duration {
+		delay := base
+		for i := 0; i < retryCount && (max <= 0 || delay < max); i++ {
+			delay *= 2
+		}
+
+		//nolint:gosec
+		delay += time.Duration(mathrand.Int63n(int64(delay)/10 + 1))
+		if max > 0 && delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// RetryLimit returns how many times the job may be retried.
+func (j *Job) RetryLimit() int {
+	if j.Retry == nil {
+		return RetryPolicyDefault
+	}
+	return *j.Retry
+}
+
+// Discardable reports whether the job failed with err should be dropped instead of
+// being sent to the dlq, which is the case for RetryPolicyEmphemeral, unless err is marked by Dead.
+func (j *Job) Discardable(err error) bool {
+	return j.RetryLimit() == RetryPolicyEmphemeral && !IsDead(err)
+}
+
+// Fail records err in j.Failure and tells whether the job should be retried after delay.
+// If not, the job is dead and should be sent to the dlq, unless it is Discardable.
+// Errors marked by Dead are never retried.
+//
+// Like faktory, retry_count is 0 on the first failure.
+// backoff may be nil when the broker schedules the retries itself.
+func (j *Job) Fail(err error, backoff Backoff) (retry bool, delay time.Duration) {
+	now := time.Now().UTC()
+
+	if j.Failure == nil {
+		j.Failure = &Failure{}
+	} else {
+		j.Failure.RetryCount++
+	}
+	j.Failure.FailedAt = now.Format(time.RFC3339Nano)
+	j.Failure.NextAt = ""
+	j.Failure.ErrorMessage = err.Error()
+	j.Failure.ErrorType = fmt.Sprintf("%T", err)
+	j.Failure.Backtrace = backtrace(j.Backtrace)
+
+	j.Failure.RetryRemaining = j.RetryLimit() - j.Failure.RetryCount - 1
+	if j.Failure.RetryRemaining < 0 || IsDead(err) {
+		j.Failure.RetryRemaining = 0
+	}
+
+	if j.Failure.RetryCount >= j.RetryLimit() || IsDead(err) {
+		return false, 0
+	}
+
+	if backoff == nil {
+		return true, 0
+	}
+	delay = backoff(j.Failure.RetryCount)
+	j.Failure.NextAt = now.Add(delay).Format(time.RFC3339Nano)
+	return true, delay
+}
+
+// backtrace returns at most n lines of the current goroutine stack
+func backtrace(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(debug.Stack())), "\n")
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return lines
+}
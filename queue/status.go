@@ -0,0 +1,310 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/cacher"
+	"github.com/zeromicro/go-zero/core/logx"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	StatusEnqueued  = "enqueued"
+	StatusScheduled = "scheduled" // pushed with a future At
+	StatusRunning   = "running"
+	StatusFailed    = "failed" // the last attempt failed, the job will be retried
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead" // the job failed for good, it was discarded or moved to the dlq
+
+	statusKeyPrefix         = "queue:status:"
+	DefaultStatusExpiration = 24 * time.Hour
+
+	// the times of a status have a fixed width, so they compare as strings
+	statusTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// statusScript applies a statusUpdate to the status hash of a job.
+//
+// ARGV[1] expiration in ms, ARGV[2] attempts delta, ARGV[3] since, ARGV[4] the number of pairs always set,
+// then the field value pairs always set, then those not set if the status was updated after since
+var statusScript = bizredis.NewScript(`local kept = ARGV[3] ~= "" and (redis.call("HGET", KEYS[1], "updated_at") or "") > ARGV[3]
+local always = 4 + 2 * tonumber(ARGV[4])
+for i = 5, always, 2 do
+    redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+if not kept then
+    for i = always + 1, #ARGV, 2 do
+        redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+    end
+    if tonumber(ARGV[2]) ~= 0 and redis.call("HINCRBY", KEYS[1], "attempts", ARGV[2]) < 0 then
+        redis.call("HSET", KEYS[1], "attempts", 0)
+    end
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return 1`)
+
+var statusGetScript = bizredis.NewScript(`return redis.call("HGETALL", KEYS[1])`)
+
+var ErrStatusNotFound = errors.New("go-zero-utils: job status not found")
+
+// JobStatus is the lifecycle of a job, as recorded by the queue clients
+type JobStatus struct {
+	Jid       string `json:"jid"`
+	Queue     string `json:"queue"`
+	Type      string `json:"jobtype"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"` // how many times the job started
+	LastError string `json:"last_error,omitempty"`
+
+	Progress     int    `json:"progress"` // reported by Helper.TrackProgress
+	ProgressDesc string `json:"progress_desc,omitempty"`
+
+	EnqueuedAt string `json:"enqueued_at,omitempty"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// Finished reports whether the job will not run anymore
+func (s *JobStatus) Finished() bool {
+	return s.Status == StatusSucceeded || s.Status == StatusDead
+}
+
+// StatusStore records the status of jobs in a cacher, bizredis to share it between replicas,
+// or bizmemory for a single process. It is set on the clients by SetStatusStore,
+// which update it through the job lifecycle, all methods are no-op on a nil store.
+//
+// Every update sets its own fields only, a redis hash by a script, so concurrent updates of a job,
+// e.g. Progress while it fails, do not overwrite each other.
+type StatusStore struct {
+	cacher     cacher.BasicCacher
+	redis      bizredis.RedisScripter // set if cacher is bizredis
+	expiration time.Duration
+
+	mu sync.Mutex // serializes the updates in any other cacher
+}
+
+// statusUpdate is applied to the status of a job at once
+type statusUpdate struct {
+	always   []string // field value pairs
+	fields   []string // field value pairs, not set if the status was updated after since
+	attempts int      // added to attempts, which stays >= 0, unless the status was updated after since
+	since    string   // "" to apply the update in any case
+}
+
+// NewStatusStore keeps every status for expiration after its last update, DefaultStatusExpiration if <= 0
+func NewStatusStore(cacher cacher.BasicCacher, expiration time.Duration) *StatusStore {
+	if expiration <= 0 {
+		expiration = DefaultStatusExpiration
+	}
+	redis, _ := cacher.(bizredis.RedisScripter)
+	return &StatusStore{cacher: cacher, redis: redis, expiration: expiration}
+}
+
+// Get returns the status of the job jid, or ErrStatusNotFound
+func (s *StatusStore) Get(jid string) (*JobStatus, error) {
+	if s == nil {
+		return nil, ErrStatusNotFound
+	}
+
+	fields, err := s.fields(jid)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) <= 0 {
+		return nil, ErrStatusNotFound
+	}
+
+	attempts, _ := strconv.Atoi(fields["attempts"])
+	progress, _ := strconv.Atoi(fields["progress"])
+	return &JobStatus{
+		Jid:          fields["jid"],
+		Queue:        fields["queue"],
+		Type:         fields["jobtype"],
+		Status:       fields["status"],
+		Attempts:     attempts,
+		LastError:    fields["last_error"],
+		Progress:     progress,
+		ProgressDesc: fields["progress_desc"],
+		EnqueuedAt:   fields["enqueued_at"],
+		StartedAt:    fields["started_at"],
+		FinishedAt:   fields["finished_at"],
+		UpdatedAt:    fields["updated_at"],
+	}, nil
+}
+
+// Enqueued is called once the job is pushed, whose push started at pushedAt: a job which already
+// started in the meantime keeps its status.
+func (s *StatusStore) Enqueued(job *Job, pushedAt time.Time) {
+	status := StatusEnqueued
+	if len(job.At) > 0 {
+		if at, err := time.Parse(time.RFC3339Nano, job.At); err == nil && at.After(time.Now()) {
+			status = StatusScheduled
+		}
+	}
+
+	now := statusTime(time.Now())
+	s.update(job, statusUpdate{
+		always: []string{"enqueued_at", now},
+		fields: []string{"status", status, "updated_at", now},
+		since:  statusTime(pushedAt),
+	})
+}
+
+// Running is called when an attempt starts
+func (s *StatusStore) Running(job *Job) {
+	now := statusTime(time.Now())
+	s.update(job, statusUpdate{
+		fields:   []string{"status", StatusRunning, "started_at", now, "updated_at", now},
+		attempts: 1,
+	})
+}
+
+// Succeeded is called when the job succeeds
+func (s *StatusStore) Succeeded(job *Job) {
+	now := statusTime(time.Now())
+	s.update(job, statusUpdate{
+		fields: []string{"status", StatusSucceeded, "finished_at", now, "updated_at", now},
+	})
+}
+
+// Failed is called when an attempt fails, dead is true if the job will not be retried
+func (s *StatusStore) Failed(job *Job, err error, dead bool) {
+	now := statusTime(time.Now())
+	fields := []string{"status", StatusFailed, "updated_at", now}
+	if dead {
+		fields = []string{"status", StatusDead, "finished_at", now, "updated_at", now}
+	}
+	if err != nil {
+		fields = append(fields, "last_error", err.Error())
+	}
+	s.update(job, statusUpdate{fields: fields})
+}
+
+// Snoozed is called when the running job is delayed by Snooze, the attempt does not count
+func (s *StatusStore) Snoozed(job *Job) {
+	s.update(job, statusUpdate{
+		fields:   []string{"status", StatusScheduled, "updated_at", statusTime(time.Now())},
+		attempts: -1,
+	})
+}
+
+// Progress records the progress reported by the job, see Helper.TrackProgress
+func (s *StatusStore) Progress(job *Job, percent int, desc string) {
+	s.update(job, statusUpdate{
+		fields: []string{"progress", strconv.Itoa(percent), "progress_desc", desc, "updated_at", statusTime(time.Now())},
+	})
+}
+
+func (s *StatusStore) update(job *Job, update statusUpdate) {
+	if s == nil {
+		return
+	}
+
+	update.always = append([]string{"jid", job.Jid, "queue", job.Queue, "jobtype", job.Type}, update.always...)
+	var err error
+	if s.redis != nil {
+		err = s.updateRedis(job.Jid, update)
+	} else {
+		err = s.updateCacher(job.Jid, update)
+	}
+	if err != nil {
+		logx.Errorf("go-zero-utils: job status %s: %v", job.Jid, err)
+	}
+}
+
+func (s *StatusStore) updateRedis(jid string, update statusUpdate) error {
+	args := []any{s.expiration.Milliseconds(), update.attempts, update.since, len(update.always) / 2}
+	for _, arg := range append(update.always, update.fields...) {
+		args = append(args, arg)
+	}
+
+	_, err := s.redis.ScriptRunCtx(context.Background(), statusScript, []string{statusKeyPrefix + jid}, args...)
+	return err
+}
+
+// updateCacher is a read-modify-write of the fields, serialized by s.mu
+func (s *StatusStore) updateCacher(jid string, update statusUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fields, err := s.fields(jid)
+	if err != nil {
+		return err
+	}
+	if fields == nil {
+		fields = make(map[string]string)
+	}
+
+	set := func(pairs []string) {
+		for i := 0; i+1 < len(pairs); i += 2 {
+			fields[pairs[i]] = pairs[i+1]
+		}
+	}
+	set(update.always)
+	if len(update.since) <= 0 || fields["updated_at"] <= update.since {
+		set(update.fields)
+		if update.attempts != 0 {
+			attempts, _ := strconv.Atoi(fields["attempts"])
+			if attempts += update.attempts; attempts < 0 {
+				attempts = 0
+			}
+			fields["attempts"] = strconv.Itoa(attempts)
+		}
+	}
+
+	fieldsJson, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if !s.cacher.Put(statusKeyPrefix+jid, string(fieldsJson), time.Now().Add(s.expiration)) {
+		return errors.New("save failed")
+	}
+	return nil
+}
+
+// fields returns the fields of the status of the job jid, nil if it has none
+func (s *StatusStore) fields(jid string) (map[string]string, error) {
+	if s.redis != nil {
+		resp, err := s.redis.ScriptRunCtx(context.Background(), statusGetScript, []string{statusKeyPrefix + jid})
+		if err != nil {
+			return nil, err
+		}
+
+		values, _ := resp.([]interface{})
+		fields := make(map[string]string, len(values)/2)
+		for i := 0; i+1 < len(values); i += 2 {
+			fields[fmt.Sprint(values[i])] = fmt.Sprint(values[i+1])
+		}
+		return fields, nil
+	}
+
+	var fieldsJson []byte
+	switch v := s.cacher.Get(statusKeyPrefix + jid).(type) {
+	case nil:
+		return nil, nil
+	case string:
+		fieldsJson = []byte(v)
+	case []byte:
+		fieldsJson = v
+	case error:
+		return nil, v
+	default:
+		return nil, fmt.Errorf("go-zero-utils: invalid job status %v", v)
+	}
+
+	var fields map[string]string
+	if err := json.Unmarshal(fieldsJson, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func statusTime(t time.Time) string {
+	return t.UTC().Format(statusTimeLayout)
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/toby1991/go-zero-utils/bizmemory"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"github.com/toby1991/go-zero-utils/cacher"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatusStore(t *testing.T) {
+	backends := map[string]func(t *testing.T) cacher.BasicCacher{
+		"redis": func(t *testing.T) cacher.BasicCacher {
+			redis := miniredis.RunT(t)
+			port, _ := strconv.Atoi(redis.Port())
+			return bizredis.NewRedis(bizredis.BizRedisConf{Host: redis.Host(), Port: port, Prefix: "test"})
+		},
+		"memory": func(t *testing.T) cacher.BasicCacher {
+			return bizmemory.NewMemory(bizmemory.BizMemoryConf{DefaultExpirationMinute: 1, CleanUpIntervalMinute: 1})
+		},
+	}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			store := NewStatusStore(backend(t), 0)
+
+			job := NewJob("kline_filling", 1)
+			if _, err := store.Get(job.Jid); err != ErrStatusNotFound {
+				t.Fatalf("Get() error = %v, want ErrStatusNotFound", err)
+			}
+
+			// the worker started the job before the client recorded its push
+			pushedAt := time.Now()
+			store.Running(job)
+			store.Enqueued(job, pushedAt)
+			if got, _ := store.Get(job.Jid); got == nil || got.Status != StatusRunning || got.Attempts != 1 || len(got.EnqueuedAt) <= 0 {
+				t.Fatalf("Get() = %+v, want running since its first attempt", got)
+			}
+
+			// the progress and the failure do not overwrite each other
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					store.Progress(job, 50, "half way")
+				}()
+				go func() {
+					defer wg.Done()
+					store.Failed(job, errors.New("test error"), false)
+				}()
+			}
+			wg.Wait()
+			store.Snoozed(job)
+			store.Snoozed(job)
+
+			got, err := store.Get(job.Jid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != StatusScheduled || got.Attempts != 0 || got.LastError != "test error" || got.Progress != 50 || got.ProgressDesc != "half way" || got.Type != job.Type {
+				t.Errorf("Get() = %+v, want scheduled with its progress and last error", got)
+			}
+
+			// a dead job replayed is enqueued again
+			store.Failed(job, nil, true)
+			store.Enqueued(job, time.Now())
+			if got, _ := store.Get(job.Jid); got == nil || got.Status != StatusEnqueued {
+				t.Errorf("Get() = %+v, want enqueued again", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"context"
+	ztrace "github.com/zeromicro/go-zero/core/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the W3C trace context and baggage travel in Job.Custom, under the keys of their http headers
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// customCarrier adapts Job.Custom to propagation.TextMapCarrier
+type customCarrier struct {
+	job *Job
+}
+
+func (c customCarrier) Get(key string) string {
+	if val, ok := c.job.GetCustom(key); ok {
+		if s, ok := val.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+func (c customCarrier) Set(key string, value string) {
+	c.job.SetCustom(key, value)
+}
+func (c customCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.job.Custom))
+	for key := range c.job.Custom {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// InjectTrace writes the trace context and baggage of ctx into job, nothing is written if ctx has none,
+// so a job keeps the trace it was created in, e.g. by the outbox, when it is pushed later without one.
+func InjectTrace(ctx context.Context, job *Job) {
+	tracePropagator.Inject(ctx, customCarrier{job: job})
+}
+
+// ExtractTrace returns ctx with the trace context and baggage carried by job
+func ExtractTrace(ctx context.Context, job *Job) context.Context {
+	return tracePropagator.Extract(ctx, customCarrier{job: job})
+}
+
+// StartPushSpan starts the producer span of pushing job, in the trace of ctx, or of job if ctx has none,
+// and injects it into job. The client ends it once the job is pushed, see EndSpan.
+func StartPushSpan(ctx context.Context, job *Job) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = ExtractTrace(ctx, job)
+	}
+
+	ctx, span := tracer().Start(ctx, "push "+job.Type,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(jobAttributes(job)...),
+	)
+	InjectTrace(ctx, job)
+	return ctx, span
+}
+
+// StartJobSpan starts the consumer span of running job, as a child of the span which pushed it,
+// the processor receives its context. The client ends it once the job returns, see EndSpan.
+func StartJobSpan(ctx context.Context, job *Job) (context.Context, trace.Span) {
+	attempt := 1
+	if job.Failure != nil {
+		attempt = job.Failure.RetryCount + 1
+	}
+
+	return tracer().Start(ExtractTrace(ctx, job), "process "+job.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(append(jobAttributes(job), attribute.Int("attempt", attempt))...),
+	)
+}
+
+// EndSpan records err in span, then ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracer of the global provider set by go-zero's Telemetry config, the span of an extracted remote context
+// has no provider to start the children with.
+func tracer() trace.Tracer {
+	return otel.Tracer(ztrace.TraceName)
+}
+
+func jobAttributes(job *Job) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("jid", job.Jid),
+		attribute.String("jobtype", job.Type),
+		attribute.String("queue", job.Queue),
+	}
+}
@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestJobSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	tests := []struct {
+		name   string
+		parent bool // push within a span
+		err    error
+	}{
+		{name: "push in a trace", parent: true},
+		{name: "push without a trace", parent: false},
+		{name: "job fails", parent: true, err: errors.New("test error")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var parent trace.Span
+			if tt.parent {
+				ctx, parent = provider.Tracer("test").Start(ctx, "request")
+				defer parent.End()
+			}
+
+			job := NewJob("kline_filling", 1)
+			_, pushSpan := StartPushSpan(ctx, job)
+			EndSpan(pushSpan, nil)
+			if _, ok := job.GetCustom("traceparent"); !ok {
+				t.Fatalf("traceparent not injected, custom = %v", job.Custom)
+			}
+
+			// the consumer gets the job through the broker
+			jobJsonBytes, _ := job.JsonBytes()
+			var consumed Job
+			if err := json.Unmarshal(jobJsonBytes, &consumed); err != nil {
+				t.Fatal(err)
+			}
+
+			jobCtx, jobSpan := StartJobSpan(context.Background(), &consumed)
+			EndSpan(jobSpan, tt.err)
+
+			spans := recorder.Ended()
+			got := spans[len(spans)-1]
+			if got.Parent().SpanID() != pushSpan.SpanContext().SpanID() {
+				t.Errorf("job span parent = %s, want the push span %s", got.Parent().SpanID(), pushSpan.SpanContext().SpanID())
+			}
+			if trace.SpanContextFromContext(jobCtx).TraceID() != pushSpan.SpanContext().TraceID() {
+				t.Errorf("job span is not in the trace of the push")
+			}
+			if tt.parent && pushSpan.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+				t.Errorf("push span is not in the trace of the request")
+			}
+			if (got.Status().Description != "") != (tt.err != nil) {
+				t.Errorf("job span status = %v, err %v", got.Status(), tt.err)
+			}
+		})
+	}
+}
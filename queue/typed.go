@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// TypedProcessor processes the payload of a job created by NewTypedJob.
+type TypedProcessor[T any] func(ctx context.Context, helper Helper, payload T) error
+
+// Validator is implemented by payloads which validate themselves once decoded.
+type Validator interface {
+	Validate() error
+}
+
+// NewTypedJob builds a job whose only arg is payload marshaled to json,
+// the worker decodes it back into T, see Register.
+func NewTypedJob[T any](jobtype string, payload T) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJob(jobtype, json.RawMessage(data)), nil
+}
+
+// Register binds processor to jobType on client, args are decoded into T before
+// the processor is called. Jobs which can not be decoded or validated are sent
+// straight to the dlq, retrying them would not help.
+func Register[T any](client Client, jobType string, processor TypedProcessor[T]) {
+	client.RegisterContext(jobType, func(ctx context.Context, helper Helper, args ...interface{}) error {
+		payload, err := DecodePayload[T](args)
+		if err != nil {
+			return Dead(err)
+		}
+
+		return processor(ctx, helper, payload)
+	})
+}
+
+// DecodePayload decodes the args of a job created by NewTypedJob into T.
+func DecodePayload[T any](args []interface{}) (T, error) {
+	var payload T
+	if len(args) != 1 {
+		return payload, fmt.Errorf("go-zero-utils: typed job expects 1 arg, got %d", len(args))
+	}
+
+	// args are decoded from json as map[string]interface{}, round trip them into T
+	data, err := json.Marshal(args[0])
+	if err != nil {
+		return payload, err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("go-zero-utils: decode typed job payload: %w", err)
+	}
+
+	if validator, ok := any(&payload).(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return payload, fmt.Errorf("go-zero-utils: invalid typed job payload: %w", err)
+		}
+	}
+
+	return payload, nil
+}
+
+type deadError struct {
+	err error
+}
+
+func (e *deadError) Error() string {
+	return e.err.Error()
+}
+func (e *deadError) Unwrap() error {
+	return e.err
+}
+
+// Dead marks err as final, the job is sent to the dlq without being retried.
+func Dead(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &deadError{err: err}
+}
+
+// IsDead reports whether err was marked by Dead.
+func IsDead(err error) bool {
+	var dead *deadError
+	return errors.As(err, &dead)
+}
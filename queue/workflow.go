@@ -0,0 +1,287 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zeromicro/go-zero/core/logx"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+const (
+	customOnSuccess = "on_success" // jobs pushed once the job succeeded
+	customOnFailure = "on_failure" // job pushed once the job died
+	customGroup     = "group"      // gid of the group the job is a member of
+
+	// set on the follow-up jobs
+	customFailedJid   = "failed_jid"
+	customFailedError = "failed_error"
+	customGroupId     = "group_id"
+	customGroupOk     = "group_succeeded"
+	customGroupFailed = "group_failed"
+)
+
+var ErrNoGroupStore = errors.New("go-zero-utils: no group store is set, see SetGroupStore")
+
+// OnSuccess pushes next once j succeeded, next may have follow-ups of its own:
+//
+//	a := queue.NewJob("a").OnSuccess(queue.NewJob("b").OnSuccess(queue.NewJob("c")))
+func (j *Job) OnSuccess(next ...*Job) *Job {
+	jobs, _ := j.followUps(customOnSuccess)
+	return j.SetCustom(customOnSuccess, append(jobs, next...))
+}
+
+// OnFailure pushes compensate once j died: its retries are exhausted, or it failed with a Dead error, or it was discarded.
+// compensate gets the custom keys failed_jid and failed_error.
+func (j *Job) OnFailure(compensate *Job) *Job {
+	return j.SetCustom(customOnFailure, []*Job{compensate})
+}
+
+// followUps decodes the jobs of the custom key, they are maps once the job went through a broker
+func (j *Job) followUps(key string) ([]*Job, error) {
+	val, ok := j.GetCustom(key)
+	if !ok {
+		return nil, nil
+	}
+	if jobs, ok := val.([]*Job); ok {
+		return jobs, nil
+	}
+
+	jobsJson, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	if err := json.Unmarshal(jobsJson, &jobs); err != nil {
+		return nil, fmt.Errorf("go-zero-utils: invalid %s of job %s: %w", key, j.Jid, err)
+	}
+	return jobs, nil
+}
+
+// Gid returns the group job is a member of
+func (j *Job) Gid() (string, bool) {
+	val, ok := j.GetCustom(customGroup)
+	if !ok {
+		return "", false
+	}
+	gid, ok := val.(string)
+	return gid, ok
+}
+
+// GroupStore tracks the members of the groups, so their callback is pushed once, after the last one finished
+type GroupStore interface {
+	// Add records the group gid of size members, callback is returned by Done once they all finished
+	Add(ctx context.Context, gid string, size int, callback *Job) error
+	// Done records that member jid finished, it returns the callback if it was the last one,
+	// with the custom keys group_id, group_succeeded and group_failed set. A member reported twice counts once.
+	Done(ctx context.Context, gid string, jid string, failed bool) (*Job, error)
+}
+
+// ContextPusher is implemented by the clients, see Client.PushCtx
+type ContextPusher interface {
+	PushCtx(ctx context.Context, job *Job) error
+}
+
+// Workflow pushes the follow-ups of the jobs finished for good, the clients call it once a job
+// succeeded or died, jobs reprocessed from the dlq are not followed up. A client which dies in between
+// pushes the follow-ups again.
+type Workflow struct {
+	pusher ContextPusher
+	groups GroupStore // nil unless set by SetGroupStore
+}
+
+func NewWorkflow(pusher ContextPusher) *Workflow {
+	return &Workflow{pusher: pusher}
+}
+
+func (w *Workflow) SetGroupStore(store GroupStore) {
+	w.groups = store
+}
+
+// PushGroup fans out members, then callback is pushed once they all succeeded or died, see GroupStore.Done.
+// Members failing to push count as died.
+func (w *Workflow) PushGroup(ctx context.Context, callback *Job, members ...*Job) (string, error) {
+	if w.groups == nil {
+		return "", ErrNoGroupStore
+	}
+
+	gid := RandomJid()
+	if len(members) <= 0 {
+		callback, err := callback.clone()
+		if err != nil {
+			return "", err
+		}
+		return gid, w.pusher.PushCtx(ctx, groupCallback(callback, gid, 0, 0))
+	}
+	if err := w.groups.Add(ctx, gid, len(members), callback); err != nil {
+		return "", err
+	}
+
+	var pushErr error
+	for _, member := range members {
+		member.SetCustom(customGroup, gid)
+		if err := w.pusher.PushCtx(ctx, member); err != nil {
+			pushErr = err
+			w.groupDone(ctx, member, true)
+		}
+	}
+	return gid, pushErr
+}
+
+// Succeeded pushes the OnSuccess jobs of job
+func (w *Workflow) Succeeded(ctx context.Context, job *Job) {
+	next, err := job.followUps(customOnSuccess)
+	if err != nil {
+		logx.Error(err)
+	}
+	w.push(ctx, job, next)
+	w.groupDone(ctx, job, false)
+}
+
+// Dead pushes the OnFailure job of job
+func (w *Workflow) Dead(ctx context.Context, job *Job) {
+	compensate, err := job.followUps(customOnFailure)
+	if err != nil {
+		logx.Error(err)
+	}
+	for _, c := range compensate {
+		c.SetCustom(customFailedJid, job.Jid)
+		if job.Failure != nil {
+			c.SetCustom(customFailedError, job.Failure.ErrorMessage)
+		}
+	}
+	w.push(ctx, job, compensate)
+	w.groupDone(ctx, job, true)
+}
+
+func (w *Workflow) groupDone(ctx context.Context, job *Job, failed bool) {
+	gid, ok := job.Gid()
+	if !ok {
+		return
+	}
+	if w.groups == nil {
+		logx.Errorf("go-zero-utils: job %s of group %s finished, %v", job.Jid, gid, ErrNoGroupStore)
+		return
+	}
+
+	callback, err := w.groups.Done(ctx, gid, job.Jid, failed)
+	if err != nil {
+		logx.Errorf("go-zero-utils: job %s of group %s finished: %v", job.Jid, gid, err)
+		return
+	}
+	if callback != nil {
+		w.push(ctx, job, []*Job{callback})
+	}
+}
+
+// push follow-ups of job, in the trace of job unless ctx has one
+func (w *Workflow) push(ctx context.Context, job *Job, jobs []*Job) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = ExtractTrace(ctx, job)
+	}
+
+	for _, next := range jobs {
+		if err := w.pusher.PushCtx(ctx, next); err != nil {
+			logx.Errorf("go-zero-utils: push follow-up %s of job %s: %v", next.Jid, job.Jid, err)
+		}
+	}
+}
+
+// ensure type compatibility
+var _ GroupStore = &memoryGroupStore{}
+
+// memoryGroupStore keeps the groups in memory, for a single process
+type memoryGroupStore struct {
+	expiration time.Duration
+
+	mu     sync.Mutex
+	groups map[string]*memoryGroup
+}
+
+type memoryGroup struct {
+	size      int
+	callback  *Job // a copy, the callback of the caller is left as is
+	succeeded int
+	failed    int
+	done      map[string]struct{}
+	expireAt  time.Time
+}
+
+// NewMemoryGroupStore keeps an unfinished group for expiration, DefaultGroupExpiration if <= 0
+func NewMemoryGroupStore(expiration time.Duration) *memoryGroupStore {
+	if expiration <= 0 {
+		expiration = DefaultGroupExpiration
+	}
+	return &memoryGroupStore{expiration: expiration, groups: make(map[string]*memoryGroup)}
+}
+
+func (s *memoryGroupStore) Add(ctx context.Context, gid string, size int, callback *Job) error {
+	callback, err := callback.clone()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the groups whose members were lost never finish
+	now := time.Now()
+	for id, group := range s.groups {
+		if now.After(group.expireAt) {
+			delete(s.groups, id)
+		}
+	}
+
+	s.groups[gid] = &memoryGroup{size: size, callback: callback, done: make(map[string]struct{}), expireAt: now.Add(s.expiration)}
+	return nil
+}
+
+func (s *memoryGroupStore) Done(ctx context.Context, gid string, jid string, failed bool) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[gid]
+	if !ok || time.Now().After(group.expireAt) {
+		return nil, nil
+	}
+	if _, ok := group.done[jid]; ok {
+		return nil, nil
+	}
+	group.done[jid] = struct{}{}
+	if failed {
+		group.failed++
+	} else {
+		group.succeeded++
+	}
+
+	if len(group.done) < group.size {
+		return nil, nil
+	}
+	delete(s.groups, gid)
+	return groupCallback(group.callback, gid, group.succeeded, group.failed), nil
+}
+
+// clone copies j through json, as a broker would
+func (j *Job) clone() (*Job, error) {
+	jobJsonBytes, err := j.JsonBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(jobJsonBytes, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// groupCallback sets the custom keys of the group on callback, a copy owned by the store
+func groupCallback(callback *Job, gid string, succeeded, failed int) *Job {
+	return callback.
+		SetCustom(customGroupId, gid).
+		SetCustom(customGroupOk, succeeded).
+		SetCustom(customGroupFailed, failed)
+}
@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"strconv"
+	"time"
+)
+
+const (
+	groupKeyPrefix = "queue:group:" // hash of size, succeeded, failed and callback, plus a set of the done members at <key>:done
+
+	DefaultGroupExpiration = 7 * 24 * time.Hour
+)
+
+var (
+	groupAddScript = bizredis.NewScript(`redis.call("HSET", KEYS[1], "size", ARGV[1], "succeeded", 0, "failed", 0, "callback", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1`)
+
+	// groupDoneScript counts a member once, it returns the callback and the counts when the last one finished
+	groupDoneScript = bizredis.NewScript(`if redis.call("EXISTS", KEYS[1]) == 0 then
+    return false
+end
+if redis.call("SADD", KEYS[2], ARGV[1]) == 0 then
+    return false
+end
+redis.call("PEXPIRE", KEYS[2], ARGV[3])
+redis.call("HINCRBY", KEYS[1], ARGV[2], 1)
+local group = redis.call("HMGET", KEYS[1], "size", "succeeded", "failed", "callback")
+if tonumber(group[2]) + tonumber(group[3]) < tonumber(group[1]) then
+    return false
+end
+redis.call("DEL", KEYS[1], KEYS[2])
+return {group[4], group[2], group[3]}`)
+)
+
+// ensure type compatibility
+var _ GroupStore = &redisGroupStore{}
+
+// redisGroupStore keeps the groups in redis, shared by every replica
+type redisGroupStore struct {
+	redis      bizredis.RedisScripter
+	expiration time.Duration
+}
+
+// NewRedisGroupStore keeps an unfinished group for expiration, DefaultGroupExpiration if <= 0
+func NewRedisGroupStore(redis bizredis.RedisScripter, expiration time.Duration) *redisGroupStore {
+	if expiration <= 0 {
+		expiration = DefaultGroupExpiration
+	}
+	return &redisGroupStore{redis: redis, expiration: expiration}
+}
+
+func (s *redisGroupStore) Add(ctx context.Context, gid string, size int, callback *Job) error {
+	callbackJsonBytes, err := callback.JsonBytes()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.ScriptRunCtx(ctx, groupAddScript, []string{groupKeyPrefix + gid},
+		strconv.Itoa(size), string(callbackJsonBytes), strconv.FormatInt(s.expiration.Milliseconds(), 10))
+	return err
+}
+
+func (s *redisGroupStore) Done(ctx context.Context, gid string, jid string, failed bool) (*Job, error) {
+	field := "succeeded"
+	if failed {
+		field = "failed"
+	}
+
+	resp, err := s.redis.ScriptRunCtx(ctx, groupDoneScript, []string{groupKeyPrefix + gid, groupKeyPrefix + gid + ":done"},
+		jid, field, strconv.FormatInt(s.expiration.Milliseconds(), 10))
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values, ok := resp.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, nil
+	}
+	callbackJson, _ := values[0].(string)
+	succeeded, _ := strconv.Atoi(values[1].(string))
+	failures, _ := strconv.Atoi(values[2].(string))
+
+	var callback Job
+	if err := json.Unmarshal([]byte(callbackJson), &callback); err != nil {
+		return nil, err
+	}
+	return groupCallback(&callback, gid, succeeded, failures), nil
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/toby1991/go-zero-utils/bizredis"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGroupStore(t *testing.T) {
+	const expiration = time.Minute
+
+	stores := map[string]func(t *testing.T) (GroupStore, func(time.Duration)){
+		"memory": func(t *testing.T) (GroupStore, func(time.Duration)) {
+			store := NewMemoryGroupStore(expiration)
+			return store, func(d time.Duration) {
+				store.mu.Lock()
+				defer store.mu.Unlock()
+				for _, group := range store.groups {
+					group.expireAt = group.expireAt.Add(-d)
+				}
+			}
+		},
+		"redis": func(t *testing.T) (GroupStore, func(time.Duration)) {
+			redis := miniredis.RunT(t)
+			port, _ := strconv.Atoi(redis.Port())
+			store := NewRedisGroupStore(bizredis.NewRedis(bizredis.BizRedisConf{Host: redis.Host(), Port: port}), expiration)
+			return store, redis.FastForward
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store, elapse := newStore(t)
+			ctx := context.Background()
+
+			callback := NewJob("goods_synced")
+			if err := store.Add(ctx, "g1", 2, callback); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := store.Done(ctx, "g1", "1", false); err != nil || got != nil {
+				t.Fatalf("Done() = %v, %v, want no callback before the last member", got, err)
+			}
+			if got, _ := store.Done(ctx, "g1", "1", false); got != nil {
+				t.Fatal("Done() of a member twice returned the callback")
+			}
+			got, err := store.Done(ctx, "g1", "2", true)
+			if err != nil || got == nil {
+				t.Fatalf("Done() = %v, %v, want the callback", got, err)
+			}
+			if gid, _ := got.GetCustom(customGroupId); gid != "g1" {
+				t.Errorf("callback group_id = %v, want g1", gid)
+			}
+			if _, ok := callback.GetCustom(customGroupId); ok || got == callback {
+				t.Error("the callback of the caller was modified")
+			}
+
+			// a group whose members were lost expires
+			if err := store.Add(ctx, "g2", 2, callback); err != nil {
+				t.Fatal(err)
+			}
+			store.Done(ctx, "g2", "1", false)
+			elapse(2 * expiration)
+			if got, _ := store.Done(ctx, "g2", "2", false); got != nil {
+				t.Error("Done() of an expired group returned the callback")
+			}
+		})
+	}
+}